@@ -1,10 +1,11 @@
-let url = "ws://" + window.location.host + "/ws";
-let ws = new WebSocket(url);
-
-let name = "Guest" + Math.floor(Math.random() * 1000);
+// Pass the query string along to subscribe to a competition and authenticate
+// with the token in the fragment, i.e. `/?code=X#token=Y`. The fragment is
+// never sent to the server so the token doesn't end up in any logs.
+let token = new URLSearchParams(window.location.hash.substring(1)).get("token");
+let url = "ws://" + window.location.host + "/ws" + window.location.search;
+let ws = new WebSocket(url, ["bearer", token]);
 
 let chat = document.getElementById("chat");
-let text = document.getElementById("text");
 
 let now = function () {
     let iso = new Date().toISOString();
@@ -16,13 +17,6 @@ ws.onmessage = function (msg) {
     chat.innerText = line + chat.innerText;
 };
 
-text.onkeydown = function (e) {
-    if (e.keyCode === 13 && text.value !== "") {
-        ws.send("<" + name + "> " + text.value);
-        text.value = "";
-    }
-};
-
 let $table = $('#table');
 
 $(document).ready(function() {
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/bombsimon/team-betting/pkg/betting"
 	"github.com/bombsimon/team-betting/pkg/database"
@@ -16,6 +19,31 @@ import (
 )
 
 func main() {
+	keys, err := betting.KeySetFromEnv()
+	if err != nil {
+		panic(err)
+	}
+
+	linkSecret := os.Getenv("SIGNIN_LINK_SECRET")
+	if linkSecret == "" {
+		panic("no sign in link secret configured, set SIGNIN_LINK_SECRET")
+	}
+
+	linkTTL := betting.DefaultLinkTTL
+	if ttl := os.Getenv("SIGNIN_LINK_TTL"); ttl != "" {
+		if linkTTL, err = time.ParseDuration(ttl); err != nil {
+			panic(err)
+		}
+	}
+
+	var oidcProvider *betting.OIDCProvider
+
+	if oidcConfig := betting.OIDCConfigFromEnv(); oidcConfig != nil {
+		if oidcProvider, err = betting.NewOIDCProvider(context.Background(), oidcConfig); err != nil {
+			panic(err)
+		}
+	}
+
 	var (
 		router    = gin.Default()
 		wsManager = melody.New()
@@ -24,25 +52,72 @@ func main() {
 		bettingService = &betting.Service{
 			DB:          database.New(os.Getenv("DB_DSN")),
 			MailService: mail.New(),
+			Keys:        keys,
+			LinkSecret:  []byte(linkSecret),
+			LinkTTL:     linkTTL,
+			OIDC:        oidcProvider,
 		}
 
 		httpService = bhttp.Service{
-			Betting: bettingService,
-			WS:      wsManager,
-			Logger:  logger,
+			Betting:  bettingService,
+			WS:       wsManager,
+			Events:   bhttp.NewEventHub(bhttp.DefaultEventLogSize),
+			Presence: bhttp.NewPresence(),
+			Logger:   logger,
 		}
 	)
 
+	// Only trust X-Forwarded-For from the configured proxies, otherwise anyone
+	// could pick their own client IP and get around the rate limits.
+	var trustedProxies []string
+
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		trustedProxies = strings.Split(proxies, ",")
+	}
+
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		panic(err)
+	}
+
 	config := cors.DefaultConfig()
 	config.AllowAllOrigins = true
 
 	config.AddAllowHeaders("Authorization")
 	router.Use(cors.New(config))
 
-	router.POST("/email/send", httpService.SendSignInEmail)
+	// Sending sign in emails is unauthenticated so limit how often it may be
+	// done both from the same IP and for the same email.
+	rateLimits := middleware.NewMemoryStore()
+
+	router.POST(
+		"/email/send",
+		middleware.RateLimit(rateLimits, 10, time.Hour, middleware.ByIP),
+		middleware.RateLimit(rateLimits, 3, 15*time.Minute, middleware.ByEmail),
+		httpService.SendSignInEmail,
+	)
 	router.POST("/email/verify", httpService.SignInEmail)
 	router.POST("/better", httpService.AddBetter)
 
+	// Joining as a guest creates a better without authenticating so limit how
+	// often it may be done from the same IP.
+	router.POST(
+		"/competition/code/:code/guest",
+		middleware.RateLimit(rateLimits, 10, time.Hour, middleware.ByIP),
+		httpService.JoinCompetitionAsGuest,
+	)
+
+	// Starting an OIDC sign in stores the login until the callback so limit
+	// how often it may be done from the same IP.
+	router.GET(
+		"/auth/oidc/login",
+		middleware.RateLimit(rateLimits, 30, 15*time.Minute, middleware.ByIP),
+		httpService.OIDCLogin,
+	)
+
+	router.POST("/auth/oidc/callback", httpService.OIDCCallback)
+	router.POST("/auth/refresh", httpService.RefreshTokens)
+	router.POST("/auth/logout", httpService.Logout)
+
 	authed := router.Group("/")
 
 	if os.Getenv("DEVELOPMENT") == "" {
@@ -50,20 +125,45 @@ func main() {
 	}
 
 	{
+		authed.POST("/auth/logout/all", httpService.LogoutEverywhere)
+		authed.POST("/better/upgrade", httpService.UpgradeGuest)
+		authed.POST("/auth/stream-ticket", httpService.CreateStreamTicket)
+
+		authed.GET("/api-token", httpService.GetAPITokens)
+		authed.POST("/api-token", httpService.CreateAPIToken)
+		authed.DELETE("/api-token/:id", httpService.RevokeAPIToken)
+
 		authed.GET("/competition", httpService.GetCompetitions)
 		authed.POST("/competition", httpService.AddCompetition)
 		authed.GET("/competition/:id", httpService.GetCompetition)
+		authed.GET("/competition/code/:code", httpService.GetCompetitionByCode)
+		authed.POST("/competition/code/:code/join", httpService.JoinCompetition)
+		authed.PATCH("/competition/:id", httpService.UpdateCompetition)
 		authed.DELETE("/competition/:id", httpService.DeleteCompetition)
 		authed.POST("/competition/:id/lock", httpService.LockCompetition)
+		authed.POST("/competition/:id/unlock", httpService.UnlockCompetition)
 		authed.POST("/competition/:id/result", httpService.SetCompetitionResult)
+		authed.DELETE("/competition/:id/result", httpService.ClearCompetitionResult)
+		authed.GET("/competition/:id/leaderboard", httpService.GetCompetitionLeaderboard)
+		authed.GET("/competition/:id/presence", httpService.GetCompetitionPresence)
+		authed.GET("/competition/:id/audit", httpService.GetCompetitionAudit)
+		authed.POST("/competition/:id/competitor", httpService.LinkCompetitors)
+		authed.POST("/competition/:id/competitor/:competitorID", httpService.LinkCompetitors)
+		authed.DELETE("/competition/:id/competitor/:competitorID", httpService.UnlinkCompetitor)
+		authed.POST("/competition/:id/member", httpService.InviteMember)
+		authed.POST("/competition/:id/member/:betterID/promote", httpService.PromoteMember)
+		authed.POST("/competition/:id/member/:betterID/demote", httpService.DemoteMember)
+		authed.DELETE("/competition/:id/member/:betterID", httpService.RemoveMember)
 
 		authed.GET("/competitor", httpService.GetCompetitor)
 		authed.POST("/competitor", httpService.AddCompetitor)
 		authed.GET("/competitor/:id", httpService.GetCompetitor)
+		authed.PATCH("/competitor/:id", httpService.UpdateCompetitor)
 		authed.DELETE("/competitor/:id", httpService.DeleteCompetitor)
 
 		authed.GET("/better", httpService.GetBetters)
 		authed.GET("/better/:id", httpService.GetBetter)
+		authed.PATCH("/better/:id", httpService.UpdateBetter)
 		authed.DELETE("/better/:id", httpService.DeleteBetter)
 
 		authed.GET("/bet", httpService.GetBets)
@@ -78,19 +178,23 @@ func main() {
 		http.ServeFile(c.Writer, c.Request, "./cmd/betting/index.html")
 	})
 
-	router.GET("/ws", func(c *gin.Context) {
-		if err := wsManager.HandleRequest(c.Writer, c.Request); err != nil {
-			logger.Printf("could not handle WS request: %s", err.Error())
-		}
-	})
+	router.GET("/ws", httpService.HandleWebsocket)
 
-	// Just broadcast all messages to everyone when a connection is upgrade to
-	// websocket.
-	wsManager.HandleMessage(func(s *melody.Session, msg []byte) {
-		if err := wsManager.Broadcast(msg); err != nil {
-			logger.Printf("could not broadcast ws message")
-		}
-	})
+	router.GET("/competition/:id/events", httpService.StreamCompetitionEvents)
+
+	// Accept the JWT as a subprotocol since browsers can't set headers when
+	// connecting to a websocket.
+	wsManager.Upgrader.Subprotocols = []string{bhttp.WebsocketProtocol}
+	wsManager.HandleConnect(httpService.HandleWebsocketConnect)
+	wsManager.HandleDisconnect(httpService.HandleWebsocketDisconnect)
+	wsManager.HandleMessage(httpService.HandleWebsocketMessage)
+	wsManager.HandlePong(httpService.HandleWebsocketPong)
+
+	// Scheduled locks are only kept in memory so they're read back from the
+	// database when starting.
+	if err := httpService.LoadScheduledLocks(); err != nil {
+		panic(err)
+	}
 
 	if err := router.Run(":5000"); err != nil {
 		panic(err)
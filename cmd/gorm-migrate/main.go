@@ -30,6 +30,25 @@ func main() {
 	db.AutoMigrate(&pkg.Competition{}).
 		AddForeignKey("created_by_id", "better(id)", "CASCADE", "CASCADE")
 
+	// Betters and competitions references each other so add the foreign key
+	// for guests when both tables exist.
+	db.Model(&pkg.Better{}).
+		AddForeignKey("guest_competition_id", "competition(id)", "CASCADE", "CASCADE")
+
+	db.AutoMigrate(&pkg.CompetitionMember{}).
+		AddForeignKey("competition_id", "competition(id)", "CASCADE", "CASCADE").
+		AddForeignKey("better_id", "better(id)", "CASCADE", "CASCADE")
+
+	db.AutoMigrate(&pkg.RefreshToken{}).
+		AddForeignKey("better_id", "better(id)", "CASCADE", "CASCADE")
+
+	db.AutoMigrate(&pkg.APIToken{}).
+		AddForeignKey("better_id", "better(id)", "CASCADE", "CASCADE")
+
+	db.AutoMigrate(&pkg.AuditEntry{}).
+		AddForeignKey("competition_id", "competition(id)", "CASCADE", "CASCADE").
+		AddForeignKey("better_id", "better(id)", "SET NULL", "CASCADE")
+
 	db.AutoMigrate(&pkg.Result{}).
 		AddForeignKey("competition_id", "competition(id)", "CASCADE", "CASCADE").
 		AddForeignKey("competitor_id", "competitor(id)", "CASCADE", "CASCADE")
@@ -50,8 +69,8 @@ func main() {
 
 func testAddData(db *gorm.DB) {
 	betters := []*pkg.Better{
-		{Name: "Testy Testsson", Email: "testy@testsson.se", Confirmed: true},
-		{Name: "Another Tester", Email: "testy@anotherone.se"},
+		{Name: "Testy Testsson", Email: null.StringFrom("testy@testsson.se"), Confirmed: true},
+		{Name: "Another Tester", Email: null.StringFrom("testy@anotherone.se")},
 	}
 
 	for _, b := range betters {
@@ -0,0 +1,11 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+ALTER TABLE competition
+    ADD COLUMN scoring_rule VARCHAR(20) NOT NULL DEFAULT 'distance' AFTER max_score;
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+ALTER TABLE competition
+    DROP COLUMN scoring_rule;
@@ -0,0 +1,28 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+-- Codes are used to join a competition and must be unique.
+ALTER TABLE competition
+    ADD CONSTRAINT idx_competition_code UNIQUE (code);
+
+-- A member is a better who has joined a competition, i.e. a participant in the
+-- competition lobby. A better can only be a member of each competition once.
+CREATE TABLE competition_member (
+    id              INT PRIMARY KEY AUTO_INCREMENT,
+    created_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    competition_id  INT NOT NULL,
+    better_id       INT NOT NULL,
+
+    FOREIGN KEY (competition_id) REFERENCES competition(id) ON DELETE CASCADE,
+    FOREIGN KEY (better_id) REFERENCES better(id) ON DELETE CASCADE,
+
+    CONSTRAINT idx_competition_id_better_id UNIQUE (competition_id, better_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE utf8mb4_bin;
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP TABLE competition_member;
+
+ALTER TABLE competition
+    DROP INDEX idx_competition_code;
@@ -0,0 +1,19 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+-- The role decides what a member may do in a competition. The owner and
+-- co-hosts may add competitors, lock and set the result while participants may
+-- only bet.
+ALTER TABLE competition_member
+    ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'participant';
+
+-- Make sure the creator of existing competitions are owners.
+INSERT INTO competition_member (competition_id, better_id, role)
+    SELECT id, created_by_id, 'owner' FROM competition WHERE created_by_id IS NOT NULL
+    ON DUPLICATE KEY UPDATE role = 'owner';
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+ALTER TABLE competition_member
+    DROP COLUMN role;
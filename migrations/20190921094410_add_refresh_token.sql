@@ -0,0 +1,25 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+-- Refresh tokens are stored hashed. All tokens rotated from the same sign in
+-- share a family so the whole family can be revoked if a token is reused.
+CREATE TABLE refresh_token (
+    id          INT PRIMARY KEY AUTO_INCREMENT,
+    created_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    better_id   INT NOT NULL,
+    token_hash  CHAR(64) NOT NULL,
+    family_id   VARCHAR(36) NOT NULL,
+    expires_at  TIMESTAMP NOT NULL,
+    used_at     TIMESTAMP NULL,
+    revoked_at  TIMESTAMP NULL,
+
+    FOREIGN KEY (better_id) REFERENCES better(id) ON DELETE CASCADE,
+
+    CONSTRAINT idx_refresh_token_token_hash UNIQUE (token_hash),
+    INDEX idx_refresh_token_family_id (family_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE utf8mb4_bin;
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP TABLE refresh_token;
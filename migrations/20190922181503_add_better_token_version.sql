@@ -0,0 +1,13 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+-- The token version is set in every JWT issued to the better. Bumping it
+-- invalidates all JWTs issued to the better.
+ALTER TABLE better
+    ADD COLUMN token_version INT NOT NULL DEFAULT 0;
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+ALTER TABLE better
+    DROP COLUMN token_version;
@@ -0,0 +1,24 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+-- Personal API tokens used by scripts and bots. Only the hash of the token is
+-- stored and the scope limits what the token may be used for.
+CREATE TABLE api_token (
+    id            INT PRIMARY KEY AUTO_INCREMENT,
+    created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    better_id     INT NOT NULL,
+    name          VARCHAR(100) NOT NULL,
+    scope         VARCHAR(20) NOT NULL,
+    token_hash    CHAR(64) NOT NULL,
+    last_used_at  TIMESTAMP NULL,
+    revoked_at    TIMESTAMP NULL,
+
+    FOREIGN KEY (better_id) REFERENCES better(id) ON DELETE CASCADE,
+
+    CONSTRAINT idx_api_token_token_hash UNIQUE (token_hash)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE utf8mb4_bin;
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP TABLE api_token;
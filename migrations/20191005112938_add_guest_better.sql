@@ -0,0 +1,20 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+-- Guests join a competition with only a name so they have no email. A guest
+-- may only take part in the competition it joined until upgraded by adding an
+-- email.
+ALTER TABLE better
+    MODIFY email VARCHAR(100) NULL,
+    ADD COLUMN guest_competition_id INT NULL,
+    ADD CONSTRAINT better_guest_competition_id_fk FOREIGN KEY (guest_competition_id) REFERENCES competition(id) ON DELETE CASCADE;
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DELETE FROM better WHERE email IS NULL;
+
+ALTER TABLE better
+    DROP FOREIGN KEY better_guest_competition_id_fk,
+    DROP COLUMN guest_competition_id,
+    MODIFY email VARCHAR(100) NOT NULL;
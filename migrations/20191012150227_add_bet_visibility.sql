@@ -0,0 +1,13 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+-- Decides when bets are visible to other betters; always, after the
+-- competition is locked or after the result is set.
+ALTER TABLE competition
+    ADD COLUMN bet_visibility VARCHAR(20) NOT NULL DEFAULT 'always';
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+ALTER TABLE competition
+    DROP COLUMN bet_visibility;
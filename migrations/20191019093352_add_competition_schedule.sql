@@ -0,0 +1,17 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+-- Bets may only be placed between opens_at and lock_at, if set. The
+-- competition is locked automatically at lock_at.
+ALTER TABLE competition
+    ADD COLUMN opens_at TIMESTAMP NULL,
+    ADD COLUMN lock_at TIMESTAMP NULL,
+    ADD INDEX idx_competition_lock_at (lock_at);
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+ALTER TABLE competition
+    DROP INDEX idx_competition_lock_at,
+    DROP COLUMN lock_at,
+    DROP COLUMN opens_at;
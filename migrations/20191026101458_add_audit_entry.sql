@@ -0,0 +1,25 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+
+-- Actions in a competition that should be possible to follow up on, such as
+-- the owner overriding the lock to correct bets. The better is null for
+-- actions done by the service itself.
+CREATE TABLE audit_entry (
+    id              INT PRIMARY KEY AUTO_INCREMENT,
+    created_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    competition_id  INT NOT NULL,
+    better_id       INT NULL,
+    action          VARCHAR(30) NOT NULL,
+    bet_id          INT NULL,
+    details         TEXT NULL,
+
+    FOREIGN KEY (competition_id) REFERENCES competition(id) ON DELETE CASCADE,
+    FOREIGN KEY (better_id) REFERENCES better(id) ON DELETE SET NULL,
+
+    INDEX idx_audit_entry_competition_id (competition_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE utf8mb4_bin;
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP TABLE audit_entry;
@@ -2,6 +2,7 @@ package pkg
 
 import (
 	"context"
+	"encoding/json"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
@@ -11,32 +12,86 @@ import (
 
 // Constants for table names in the data model.
 const (
+	APITokenTable                  = "api_token"
+	AuditEntryTable                = "audit_entry"
 	BetTable                       = "bet"
 	BetterTable                    = "better"
 	CompetitionCompetitorTable     = "competition_competitor"
+	CompetitionMemberTable         = "competition_member"
 	CompetitionTable               = "competition"
 	CompetitorTable                = "competitor"
+	RefreshTokenTable              = "refresh_token"
 	ResultTable                    = "result"
 	ResultPlacingKey               = "idx_competition_id_placing"
 	ResultCompetitionCompetitorKey = "idx_competition_id_competitor_id"
 )
 
+// Names of the scoring rules that may be used for a competition.
+const (
+	ScoringExact      = "exact"
+	ScoringDistance   = "distance"
+	ScoringEurovision = "eurovision"
+	ScoringSpearman   = "spearman"
+	ScoringKendall    = "kendall"
+	ScoringDefault    = ScoringDistance
+)
+
+// Bet visibilities decides when the bets in a competition are visible to
+// other betters than the one who placed the bet.
+const (
+	BetVisibilityAlways      = "always"
+	BetVisibilityAfterLock   = "after_lock"
+	BetVisibilityAfterResult = "after_result"
+)
+
+// Roles a better may have in a competition.
+const (
+	RoleOwner       = "owner"
+	RoleCoHost      = "cohost"
+	RoleParticipant = "participant"
+)
+
+// Scopes an API token may have. Each scope allows everything the previous
+// scope allows.
+const (
+	ScopeRead  = "read"
+	ScopeBet   = "bet"
+	ScopeAdmin = "admin"
+)
+
+// Actions recorded in the audit log for a competition.
+const (
+	AuditBetOverride       = "bet.override"
+	AuditBetDeleteOverride = "bet.delete_override"
+	AuditLocked            = "competition.locked"
+	AuditUnlocked          = "competition.unlocked"
+	AuditResultCleared     = "competition.result_cleared"
+	AuditCompetitorRemoved = "competitor.removed"
+)
+
+// APITokenPrefix is the prefix for all API tokens to tell them apart from
+// JWTs.
+const APITokenPrefix = "tb_"
+
 // Common errors returned throughout the service.
 var (
-	ErrBadRequest = errors.New("bad request")
-	ErrInternal   = errors.New("internal error")
-	ErrNotFound   = errors.New("not found")
+	ErrBadRequest   = errors.New("bad request")
+	ErrForbidden    = errors.New("forbidden")
+	ErrInternal     = errors.New("internal error")
+	ErrNotFound     = errors.New("not found")
+	ErrUnauthorized = errors.New("unauthorized")
 )
 
 // BettingService represents the service implementing how to bet on teams.
 type BettingService interface {
 	AddCompetition(ctx context.Context, competition *Competition) (*Competition, error)
 	AddCompetitor(ctx context.Context, competitor *Competitor, bindToCompetitionID *int) (*Competitor, error)
-	AddBetter(ctx context.Context, better *Better) (string, error)
+	AddBetter(ctx context.Context, better *Better) (*Tokens, error)
 	AddBet(ctx context.Context, bet *Bet) (*Bet, error)
 
 	GetCompetition(ctx context.Context, id int) (*Competition, error)
 	GetCompetitions(ctx context.Context, ids []int) ([]*Competition, error)
+	GetCompetitionByCode(ctx context.Context, code string) (*Competition, error)
 	GetCompetitor(ctx context.Context, id int) (*Competitor, error)
 	GetCompetitors(ctx context.Context, ids []int) ([]*Competitor, error)
 	GetBetter(ctx context.Context, id int) (*Better, error)
@@ -44,25 +99,62 @@ type BettingService interface {
 	GetBet(ctx context.Context, id int) (*Bet, error)
 	GetBets(ctx context.Context, ids []int) ([]*Bet, error)
 
+	UpdateCompetition(ctx context.Context, id int, patch Patch) (*Competition, error)
+	UpdateCompetitor(ctx context.Context, id int, patch Patch) (*Competitor, error)
+	UpdateBetter(ctx context.Context, id int, patch Patch) (*Better, error)
+
 	DeleteCompetition(ctx context.Context, id int) error
 	DeleteCompetitor(ctx context.Context, id int) error
 	DeleteBetter(ctx context.Context, id int) error
 	DeleteBet(ctx context.Context, id int) error
 
 	GetCompetitionMetrics(ctx context.Context, id int) (*CompetitionMetrics, error)
+	GetCompetitionLeaderboard(ctx context.Context, id int) ([]*LeaderboardEntry, error)
 	GetCompetitorsForCompetition(ctx context.Context, id int) ([]*Competitor, error)
+	LinkCompetitors(ctx context.Context, competitionID int, competitorIDs []int) ([]*Competitor, error)
+	UnlinkCompetitor(ctx context.Context, competitionID, competitorID int, force bool) error
 	GetBetsForCompetition(ctx context.Context, id int) ([]*Bet, error)
+	VisibleBet(ctx context.Context, bet *Bet) (*Bet, error)
 	GetCreatedObjectsForBetter(ctx context.Context, id int) ([]*Competition, []*Competitor, []*Bet, error)
 
 	BetterFromJWT(ctx context.Context, tokenString string) (*Better, error)
+	BetterFromToken(ctx context.Context, tokenString string) (*Better, error)
 	JWTForBetter(ctx context.Context, better *Better) (string, error)
+	JoinCompetition(ctx context.Context, code string, betterID int) (*Competition, error)
+	JoinCompetitionAsGuest(ctx context.Context, code, name string) (*Tokens, error)
+	UpgradeGuest(ctx context.Context, betterID int, email string) (*Tokens, error)
+	InviteMember(ctx context.Context, competitionID, betterID int, role string) (*CompetitionMember, error)
+	SetMemberRole(ctx context.Context, competitionID, betterID int, role string) (*CompetitionMember, error)
+	RemoveMember(ctx context.Context, competitionID, betterID int) error
 	LockCompetition(ctx context.Context, id int) error
+	UnlockCompetition(ctx context.Context, id int) error
+	GetCompetitionAudit(ctx context.Context, id int) ([]*AuditEntry, error)
+	GetScheduledLocks(ctx context.Context) ([]*Competition, error)
+	LockScheduledCompetition(ctx context.Context, id int) (bool, error)
 	SetCompetitionResult(ctx context.Context, id int, result []*Result) (*CompetitionMetrics, error)
+	ClearCompetitionResult(ctx context.Context, id int) error
 	SendSignInEmail(ctx context.Context, email string) error
-	SignInFromEmail(ctx context.Context, email, linkID string) (string, error)
+	SignInFromEmail(ctx context.Context, link string) (*Tokens, error)
+	OIDCAuthURL(ctx context.Context) (string, error)
+	SignInFromOIDC(ctx context.Context, code, state string) (*Tokens, error)
+	RefreshTokens(ctx context.Context, refreshToken string) (*Tokens, error)
+	RevokeRefreshToken(ctx context.Context, refreshToken string) error
+	SignOutEverywhere(ctx context.Context, betterID int) error
+	CreateAPIToken(ctx context.Context, token *APIToken) (*APIToken, error)
+	GetAPITokens(ctx context.Context, betterID int) ([]*APIToken, error)
+	RevokeAPIToken(ctx context.Context, id int) error
+	APITokenFromString(ctx context.Context, tokenString string) (*APIToken, error)
+}
+
+// ScoringRule represents a way to score a better's predicted placings against
+// the actual result. Both maps are keyed on competitor ID with the placing as
+// value.
+type ScoringRule interface {
+	Score(predicted, actual map[int]int) float64
 }
 
-// SignInData represents the data used for signing.
+// SignInData represents the data in a sign in link. The encoding is the signed
+// link as received from the client.
 type SignInData struct {
 	Encoding  string    `json:"encoding,omitempty"`
 	LinkID    string    `json:"link_id,omitempty"`
@@ -73,9 +165,23 @@ type SignInData struct {
 // Claims represents the claims in a JWT.
 type Claims struct {
 	jwt.StandardClaims
-	ID    int    `json:"id"`
-	Email string `json:"email"`
-	Image string `json:"image"`
+	ID      int    `json:"id"`
+	Email   string `json:"email"`
+	Image   string `json:"image"`
+	Version int    `json:"ver"`
+	Guest   int    `json:"guest,omitempty"`
+}
+
+// Patch holds the fields to update for an object keyed on the JSON field name.
+// Fields not present are left unchanged and null clears a nullable field.
+type Patch map[string]json.RawMessage
+
+// Tokens represents the tokens handed out when a better signs in. The JWT is
+// short lived and the refresh token is used to get a new pair of tokens.
+type Tokens struct {
+	JWT                   string    `json:"jwt"`
+	RefreshToken          string    `json:"refresh_token"`
+	RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
 }
 
 // MetricValue represents who has what value, e.g. who has the lowest average
@@ -97,26 +203,56 @@ type CompetitionMetrics struct {
 	NumberOfBottomScores int
 	NumberOfTopScores    int
 	GroupAverageScore    float64
+	BestPrediction       MetricValue
+	WorstPrediction      MetricValue
+}
+
+// LeaderboardEntry represents the points and rank for a better in a
+// competition when the bets has been scored against the result.
+type LeaderboardEntry struct {
+	Rank      int     `json:"rank"`
+	Better    *Better `json:"better"`
+	Points    float64 `json:"points"`
+	ExactHits int     `json:"exact_hits"`
 }
 
 // Competition represents one competition, e.g. Eurovision Song Contest 2022.
 type Competition struct {
-	ID          int                 `db:"id"          json:"id"            gorm:"primary_key"`
-	CreatedAt   time.Time           `db:"created_at"  json:"created_at"`
-	UpdatedAt   time.Time           `db:"updated_at"  json:"updated_at"`
-	DeletedAt   null.Time           `db:"deleted_at"  json:"deleted_at"`
-	CreatedBy   *Better             `db:"-"           json:"created_by"    gorm:"foreignkey:CreatedByID"`
-	CreatedByID int                 `db:"created_by"  json:"created_by_id" gorm:"not null"`
-	Name        string              `db:"name"        json:"name"          gorm:"type:varchar(100); not null"`
-	Description null.String         `db:"description" json:"description"   gorm:"type:varchar(255)"`
-	Code        null.String         `db:"code"        json:"code"          gorm:"code:varchar(10)"`
-	Image       null.String         `db:"image"       json:"image"         gorm:"type:varchar(100)"`
-	MinScore    int                 `db:"min_score"   json:"min_score"     gorm:"type:int; not null"`
-	MaxScore    int                 `db:"max_score"   json:"max_score"     gorm:"type:int; not null"`
-	Locked      bool                `db:"locked"      json:"locked"        gorm:"type:tinyint(1); default 0"`
-	Metrics     *CompetitionMetrics `db:"-"           json:"metrics"       gorm:"-"`
-	Competitors []*Competitor       `db:"-"           json:"competitors"   gorm:"many2many:competition_competitor"`
-	Bets        []*Bet              `db:"-"           json:"bets"`
+	ID            int                  `db:"id"             json:"id"             gorm:"primary_key"`
+	CreatedAt     time.Time            `db:"created_at"     json:"created_at"`
+	UpdatedAt     time.Time            `db:"updated_at"     json:"updated_at"`
+	DeletedAt     null.Time            `db:"deleted_at"     json:"deleted_at"`
+	CreatedBy     *Better              `db:"-"              json:"created_by"     gorm:"foreignkey:CreatedByID"`
+	CreatedByID   int                  `db:"created_by"     json:"created_by_id"  gorm:"not null"`
+	Name          string               `db:"name"           json:"name"           gorm:"type:varchar(100); not null"`
+	Description   null.String          `db:"description"    json:"description"    gorm:"type:varchar(255)"`
+	Code          null.String          `db:"code"           json:"code"           gorm:"type:varchar(10); unique"`
+	Image         null.String          `db:"image"          json:"image"          gorm:"type:varchar(100)"`
+	MinScore      int                  `db:"min_score"      json:"min_score"      gorm:"type:int; not null"`
+	MaxScore      int                  `db:"max_score"      json:"max_score"      gorm:"type:int; not null"`
+	ScoringRule   string               `db:"scoring_rule"   json:"scoring_rule"   gorm:"type:varchar(20); not null; default:'distance'"`
+	BetVisibility string               `db:"bet_visibility" json:"bet_visibility" gorm:"type:varchar(20); not null; default:'always'"`
+	Locked        bool                 `db:"locked"         json:"locked"         gorm:"type:tinyint(1); default 0"`
+	OpensAt       null.Time            `db:"opens_at"       json:"opens_at"`
+	LockAt        null.Time            `db:"lock_at"        json:"lock_at"        gorm:"index"`
+	Metrics       *CompetitionMetrics  `db:"-"              json:"metrics"        gorm:"-"`
+	Competitors   []*Competitor        `db:"-"              json:"competitors"    gorm:"many2many:competition_competitor"`
+	Members       []*CompetitionMember `db:"-"              json:"members"`
+	Bets          []*Bet               `db:"-"              json:"bets"`
+}
+
+// CompetitionMember represents a better participating in a competition, i.e.
+// someone who has joined the competition lobby. The role decides what the
+// member may do in the competition. The owner and co-hosts may add competitors,
+// lock the competition and set the result while participants may only bet.
+type CompetitionMember struct {
+	ID            int          `db:"id"             json:"id"             gorm:"primary_key"`
+	CreatedAt     time.Time    `db:"created_at"     json:"created_at"`
+	Competition   *Competition `db:"-"              json:"competition,omitempty"`
+	CompetitionID int          `db:"competition_id" json:"competition_id" gorm:"unique_index:idx_competition_id_better_id; not null"`
+	Better        *Better      `db:"-"              json:"better"`
+	BetterID      int          `db:"better_id"      json:"better_id"      gorm:"unique_index:idx_competition_id_better_id; not null"`
+	Role          string       `db:"role"           json:"role"           gorm:"type:varchar(20); not null; default:'participant'"`
 }
 
 // Competitor represents a team or player competing in a competition. A
@@ -144,18 +280,67 @@ type Result struct {
 	AverageScore  null.Int     `db:"-"                         json:"average_score"          gorm:"-"`
 }
 
-// Better is someone who can make a Bet on a Competitor.
+// Better is someone who can make a Bet on a Competitor. A guest is a better
+// without an email who joined a competition with only a name. A guest may only
+// take part in the competition it joined until upgraded by adding an email.
 type Better struct {
-	ID         int         `db:"id"           json:"id"           gorm:"primary_key"`
-	CreatedAt  time.Time   `db:"created_at"   json:"created_at"`
-	UpdatedAt  null.Time   `db:"updated_at"   json:"updated_at"`
-	DeletedAt  null.Time   `db:"deleted_at"   json:"deleted_at"`
-	LinkSentAt null.Time   `db:"link_sent_at" json:"link_sent_at" gorm:"type:timestamp"`
-	Confirmed  bool        `db:"confirmed"    json:"confirmed"    gorm:"type:tinyint(1); default 0"`
-	Name       string      `db:"name"         json:"name"         gorm:"type:varchar(100); not null"`
-	Email      string      `db:"email"        json:"email"        gorm:"type:varchar(100); not null; unique"`
-	Image      null.String `db:"image"        json:"image"        gorm:"type:varchar(100)"`
-	LinkID     null.String `db:"link_id"      json:"link_id"      gorm:"type:varchar(100); unique"`
+	ID                 int         `db:"id"                   json:"id"                   gorm:"primary_key"`
+	CreatedAt          time.Time   `db:"created_at"           json:"created_at"`
+	UpdatedAt          null.Time   `db:"updated_at"           json:"updated_at"`
+	DeletedAt          null.Time   `db:"deleted_at"           json:"deleted_at"`
+	LinkSentAt         null.Time   `db:"link_sent_at"         json:"link_sent_at"         gorm:"type:timestamp"`
+	Confirmed          bool        `db:"confirmed"            json:"confirmed"            gorm:"type:tinyint(1); default 0"`
+	Name               string      `db:"name"                 json:"name"                 gorm:"type:varchar(100); not null"`
+	Email              null.String `db:"email"                json:"email"                gorm:"type:varchar(100); unique"`
+	Image              null.String `db:"image"                json:"image"                gorm:"type:varchar(100)"`
+	LinkID             null.String `db:"link_id"              json:"link_id"              gorm:"type:varchar(100); unique"`
+	TokenVersion       int         `db:"token_version"        json:"-"                    gorm:"not null; default:0"`
+	GuestCompetitionID null.Int    `db:"guest_competition_id" json:"guest_competition_id" gorm:"type:int"`
+}
+
+// RefreshToken represents a refresh token handed out to a better. Only the hash
+// of the token is stored. Every time a refresh token is used it's marked as
+// used and a new one in the same family is handed out so that a reused token
+// can be detected and the whole family revoked.
+type RefreshToken struct {
+	ID        int       `db:"id"         json:"id"         gorm:"primary_key"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	Better    *Better   `db:"-"          json:"better"`
+	BetterID  int       `db:"better_id"  json:"better_id"  gorm:"not null"`
+	TokenHash string    `db:"token_hash" json:"-"          gorm:"type:char(64); not null; unique"`
+	FamilyID  string    `db:"family_id"  json:"family_id"  gorm:"type:varchar(36); not null; index"`
+	ExpiresAt time.Time `db:"expires_at" json:"expires_at" gorm:"not null"`
+	UsedAt    null.Time `db:"used_at"    json:"used_at"`
+	RevokedAt null.Time `db:"revoked_at" json:"revoked_at"`
+}
+
+// APIToken represents a named personal API token used by scripts and bots to
+// act as a better. Only the hash of the token is stored and the token itself is
+// only returned when created. The scope limits what the token may be used for.
+type APIToken struct {
+	ID         int       `db:"id"           json:"id"              gorm:"primary_key"`
+	CreatedAt  time.Time `db:"created_at"   json:"created_at"`
+	Better     *Better   `db:"-"            json:"-"`
+	BetterID   int       `db:"better_id"    json:"better_id"       gorm:"not null"`
+	Name       string    `db:"name"         json:"name"            gorm:"type:varchar(100); not null"`
+	Scope      string    `db:"scope"        json:"scope"           gorm:"type:varchar(20); not null"`
+	TokenHash  string    `db:"token_hash"   json:"-"               gorm:"type:char(64); not null; unique"`
+	Token      string    `db:"-"            json:"token,omitempty" gorm:"-"`
+	LastUsedAt null.Time `db:"last_used_at" json:"last_used_at"`
+	RevokedAt  null.Time `db:"revoked_at"   json:"revoked_at"`
+}
+
+// AuditEntry is an action in a competition recorded to be able to follow up
+// on it afterwards, such as the owner overriding the lock to correct a bet.
+// The better is null for actions done by the service itself.
+type AuditEntry struct {
+	ID            int         `db:"id"             json:"id"             gorm:"primary_key"`
+	CreatedAt     time.Time   `db:"created_at"     json:"created_at"`
+	CompetitionID int         `db:"competition_id" json:"competition_id" gorm:"not null; index"`
+	BetterID      null.Int    `db:"better_id"      json:"better_id"`
+	Action        string      `db:"action"         json:"action"         gorm:"type:varchar(30); not null"`
+	BetID         null.Int    `db:"bet_id"         json:"bet_id"`
+	Details       null.String `db:"details"        json:"details"        gorm:"type:text"`
 }
 
 // Bet is a bet put on a Competitor in a certain Competition.
@@ -172,4 +357,5 @@ type Bet struct {
 	CompetitionID int          `db:"competition_id"            json:"competition_id"         gorm:"unique_index:idx_better_id_competition_id_competitor_id; not null"`
 	Competitor    *Competitor  `db:"-"                         json:"competitor"`
 	CompetitorID  int          `db:"competitor_id"             json:"competitor_id"          gorm:"unique_index:idx_better_id_competition_id_competitor_id; not null"`
+	Hidden        bool         `db:"-"                         json:"hidden"                 gorm:"-"`
 }
@@ -0,0 +1,152 @@
+package betting
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/bombsimon/team-betting/pkg"
+)
+
+// CreateAPIToken will create a new named API token for the better making the
+// request. The token is only returned when created, after that only the hash
+// is known.
+func (s *Service) CreateAPIToken(ctx context.Context, token *pkg.APIToken) (*pkg.APIToken, error) {
+	if err := requireSession(ctx, "create API tokens"); err != nil {
+		return nil, err
+	}
+
+	if err := requireGuestAccess(ctx, 0, "create API tokens"); err != nil {
+		return nil, err
+	}
+
+	better, err := currentBetter(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := token.Validate(); err != nil {
+		return nil, errors.Wrap(err, "bad request")
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	secret = pkg.APITokenPrefix + secret
+
+	cleaned := pkg.APIToken{
+		BetterID:  better.ID,
+		Name:      token.Name,
+		Scope:     token.Scope,
+		TokenHash: hashToken(secret),
+	}
+
+	if err := s.DB.Gorm.Save(&cleaned).Error; err != nil {
+		return nil, errors.Wrap(err, "could not create API token")
+	}
+
+	cleaned.Token = secret
+
+	return &cleaned, nil
+}
+
+// GetAPITokens will return all API tokens for a better, including revoked
+// tokens. Only the better may list their tokens.
+func (s *Service) GetAPITokens(ctx context.Context, betterID int) ([]*pkg.APIToken, error) {
+	if err := requireSession(ctx, "list API tokens"); err != nil {
+		return nil, err
+	}
+
+	if err := requireOwner(ctx, betterID, "list the API tokens"); err != nil {
+		return nil, err
+	}
+
+	var tokens []*pkg.APIToken
+
+	if err := s.DB.Gorm.Where("better_id = ?", betterID).Find(&tokens).Error; err != nil {
+		return nil, errors.Wrap(err, "could not get API tokens")
+	}
+
+	return tokens, nil
+}
+
+// RevokeAPIToken will revoke an API token. Only the better owning the token may
+// revoke it.
+func (s *Service) RevokeAPIToken(ctx context.Context, id int) error {
+	if err := requireSession(ctx, "revoke API tokens"); err != nil {
+		return err
+	}
+
+	var token pkg.APIToken
+
+	if s.DB.Gorm.First(&token, id).RecordNotFound() {
+		return errors.Wrap(pkg.ErrNotFound, "no API token found")
+	}
+
+	if err := requireOwner(ctx, token.BetterID, "revoke the API token"); err != nil {
+		return err
+	}
+
+	if token.RevokedAt.Valid {
+		return nil
+	}
+
+	if err := s.DB.Gorm.Model(&token).Update("revoked_at", time.Now()).Error; err != nil {
+		return errors.Wrap(err, "could not revoke API token")
+	}
+
+	return nil
+}
+
+// APITokenFromString will return the API token, with the better it belongs to,
+// for the token string if the token is valid.
+func (s *Service) APITokenFromString(ctx context.Context, tokenString string) (*pkg.APIToken, error) {
+	if !strings.HasPrefix(tokenString, pkg.APITokenPrefix) {
+		return nil, errors.Wrap(pkg.ErrUnauthorized, "invalid API token")
+	}
+
+	var token pkg.APIToken
+
+	if s.DB.Gorm.Preload("Better").Where("token_hash = ?", hashToken(tokenString)).First(&token).RecordNotFound() {
+		return nil, errors.Wrap(pkg.ErrUnauthorized, "invalid API token")
+	}
+
+	if token.RevokedAt.Valid {
+		return nil, errors.Wrap(pkg.ErrUnauthorized, "API token has been revoked")
+	}
+
+	// The better is not found if deleted.
+	if token.Better == nil {
+		return nil, errors.Wrap(pkg.ErrUnauthorized, "better for API token not found")
+	}
+
+	if err := s.DB.Gorm.Model(&token).UpdateColumn("last_used_at", time.Now()).Error; err != nil {
+		return nil, errors.Wrap(err, "could not update API token")
+	}
+
+	return &token, nil
+}
+
+// BetterFromToken will return the better for either a JWT or an API token with
+// at least the read scope. It's used to authenticate realtime streams which
+// are read only.
+func (s *Service) BetterFromToken(ctx context.Context, tokenString string) (*pkg.Better, error) {
+	if !strings.HasPrefix(tokenString, pkg.APITokenPrefix) {
+		return s.BetterFromJWT(ctx, tokenString)
+	}
+
+	token, err := s.APITokenFromString(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireScope(pkg.ContextWithScope(ctx, token.Scope), pkg.ScopeRead, "stream events"); err != nil {
+		return nil, err
+	}
+
+	return token.Better, nil
+}
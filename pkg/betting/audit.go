@@ -0,0 +1,81 @@
+package betting
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/guregu/null"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+
+	"github.com/bombsimon/team-betting/pkg"
+)
+
+// GetCompetitionAudit returns the audit log for a competition, oldest entry
+// first. Only the owner and co-hosts of the competition may see the audit log.
+func (s *Service) GetCompetitionAudit(ctx context.Context, id int) ([]*pkg.AuditEntry, error) {
+	c, err := s.getCompetition(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireRole(ctx, c, "see the audit log", pkg.RoleOwner, pkg.RoleCoHost); err != nil {
+		return nil, err
+	}
+
+	var entries []*pkg.AuditEntry
+
+	err = s.DB.Gorm.
+		Where("competition_id = ?", id).
+		Order("id").
+		Find(&entries).
+		Error
+
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get audit log")
+	}
+
+	return entries, nil
+}
+
+// audit records an action in the audit log for the competition using the
+// passed database handle, usually a transaction also doing the action. The
+// better making the request is recorded as the one doing the action and
+// details, if any, is stored as JSON.
+func audit(ctx context.Context, db *gorm.DB, competitionID int, action string, betID null.Int, details interface{}) error {
+	entry := pkg.AuditEntry{
+		CompetitionID: competitionID,
+		Action:        action,
+		BetID:         betID,
+	}
+
+	if better, ok := pkg.BetterFromContext(ctx); ok {
+		entry.BetterID = null.IntFrom(int64(better.ID))
+	}
+
+	if details != nil {
+		b, err := json.Marshal(details)
+		if err != nil {
+			return errors.Wrap(err, "could not marshal audit details")
+		}
+
+		entry.Details = null.StringFrom(string(b))
+	}
+
+	if err := db.Save(&entry).Error; err != nil {
+		return errors.Wrap(err, "could not save audit entry")
+	}
+
+	return nil
+}
+
+// auditBet returns the details about a bet recorded in the audit log.
+func auditBet(bet *pkg.Bet) interface{} {
+	return map[string]interface{}{
+		"better_id":     bet.BetterID,
+		"competitor_id": bet.CompetitorID,
+		"score":         bet.Score,
+		"placing":       bet.Placing,
+		"note":          bet.Note,
+	}
+}
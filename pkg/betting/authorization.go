@@ -0,0 +1,117 @@
+package betting
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/bombsimon/team-betting/pkg"
+)
+
+// currentBetter returns the better making the request.
+func currentBetter(ctx context.Context) (*pkg.Better, error) {
+	better, ok := pkg.BetterFromContext(ctx)
+	if !ok {
+		return nil, errors.Wrap(pkg.ErrForbidden, "no authenticated better")
+	}
+
+	return better, nil
+}
+
+// scopeLevels orders the API token scopes, each scope allows everything the
+// scopes with a lower level allows.
+var scopeLevels = map[string]int{
+	pkg.ScopeRead:  1,
+	pkg.ScopeBet:   2,
+	pkg.ScopeAdmin: 3,
+}
+
+// requireScope ensures the API token used for the request, if any, has at
+// least the passed scope.
+func requireScope(ctx context.Context, scope, what string) error {
+	tokenScope, ok := pkg.ScopeFromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	if scopeLevels[tokenScope] < scopeLevels[scope] {
+		return errors.Wrapf(pkg.ErrForbidden, "API token with scope %s may not %s", tokenScope, what)
+	}
+
+	return nil
+}
+
+// requireSession ensures the request isn't made with an API token, i.e. that
+// the better signed in.
+func requireSession(ctx context.Context, what string) error {
+	if _, ok := pkg.ScopeFromContext(ctx); ok {
+		return errors.Wrapf(pkg.ErrForbidden, "API tokens may not %s", what)
+	}
+
+	return nil
+}
+
+// requireGuestAccess ensures a guest better making the request only takes
+// part in the competition it joined. Use competition ID 0 for actions guests
+// may never do.
+func requireGuestAccess(ctx context.Context, competitionID int, what string) error {
+	better, ok := pkg.BetterFromContext(ctx)
+	if !ok || !better.GuestCompetitionID.Valid {
+		return nil
+	}
+
+	if competitionID == 0 || int(better.GuestCompetitionID.Int64) != competitionID {
+		return errors.Wrapf(pkg.ErrForbidden, "guests may not %s", what)
+	}
+
+	return nil
+}
+
+// requireOwner ensures the better making the request is the owner of the
+// object, i.e. the better with the passed ID.
+func requireOwner(ctx context.Context, ownerID int, what string) error {
+	better, err := currentBetter(ctx)
+	if err != nil {
+		return err
+	}
+
+	if better.ID != ownerID {
+		return errors.Wrapf(pkg.ErrForbidden, "only the owner may %s", what)
+	}
+
+	return nil
+}
+
+// requireRole ensures the better making the request has one of the roles in
+// the competition.
+func requireRole(ctx context.Context, competition *pkg.Competition, what string, roles ...string) error {
+	better, err := currentBetter(ctx)
+	if err != nil {
+		return err
+	}
+
+	role := roleFor(better.ID, competition)
+
+	for _, r := range roles {
+		if r == role {
+			return nil
+		}
+	}
+
+	return errors.Wrapf(pkg.ErrForbidden, "only %s may %s", strings.Join(roles, " or "), what)
+}
+
+// roleFor returns the role for the better in the competition or an empty
+// string if the better isn't a member. The creator is always the owner.
+func roleFor(betterID int, competition *pkg.Competition) string {
+	if betterID == competition.CreatedByID {
+		return pkg.RoleOwner
+	}
+
+	if member := memberFor(betterID, competition); member != nil {
+		return member.Role
+	}
+
+	return ""
+}
@@ -2,8 +2,6 @@ package betting
 
 import (
 	"context"
-	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
@@ -15,16 +13,13 @@ import (
 	"github.com/pkg/errors"
 )
 
-const (
-	// HMACSecret is the secret to use for JWTs
-	HMACSecret = "s3cr3t"
-)
-
-// SendSignInEmail will send an email to help user sign in.
+// SendSignInEmail will send an email to help user sign in. The link is signed
+// and may only be used once. Requesting a new link invalidates any previously
+// sent link.
 func (s *Service) SendSignInEmail(ctx context.Context, email string) error {
 	var better pkg.Better
 
-	if err := s.DB.Gorm.Where("email = ?", email).Find(&better).Error; err != nil {
+	if err := s.DB.Gorm.Where("LOWER(email) = ?", normalizeEmail(email)).Find(&better).Error; err != nil {
 		return errors.Wrapf(pkg.ErrNotFound, "could not find better with email %s", email)
 	}
 
@@ -36,20 +31,20 @@ func (s *Service) SendSignInEmail(ctx context.Context, email string) error {
 		return errors.Wrap(err, "could not save reset link")
 	}
 
-	linkData := map[string]interface{}{
-		"email":   better.Email,
-		"link_id": better.LinkID.String,
-		"eat":     better.LinkSentAt.Time.Add(2 * time.Hour),
+	link, err := s.signLink(&pkg.SignInData{
+		Email:     better.Email.String,
+		LinkID:    better.LinkID.String,
+		ExpiresAt: better.LinkSentAt.Time.Add(s.linkTTL()),
+	})
+	if err != nil {
+		return err
 	}
 
-	jsonLinkData, _ := json.Marshal(linkData)
-	b64Signin := base64.StdEncoding.EncodeToString(jsonLinkData)
-
 	message := []string{
 		"<h1>Here's your sign in link!</h1>",
 		fmt.Sprintf(
 			"<p><a href=\"http://localhost:3000/login?signin=%s\">Click here to sign in.</a></p>",
-			b64Signin,
+			link,
 		),
 		"<p>If you did not request this email, just throw it away.</p>",
 		"<p>Happy betting!</p>",
@@ -63,68 +58,96 @@ func (s *Service) SendSignInEmail(ctx context.Context, email string) error {
 	})
 }
 
-// SignInFromEmail will parse email sign in data and return a JWT if valid.
-func (s *Service) SignInFromEmail(ctx context.Context, email, linkID string) (string, error) {
-	var better pkg.Better
-
-	if err := s.DB.Gorm.Where("email = ?", email).Find(&better).Error; err != nil {
-		return "", errors.Wrapf(err, "could not find better with email %s", email)
+// SignInFromEmail will verify a sign in link and return a JWT and a refresh
+// token if valid. The link is invalidated when used.
+func (s *Service) SignInFromEmail(ctx context.Context, link string) (*pkg.Tokens, error) {
+	data, err := s.parseLink(link)
+	if err != nil {
+		return nil, err
 	}
 
-	if better.LinkID.IsZero() || better.LinkID.ValueOrZero() == "" {
-		return "", errors.Wrap(pkg.ErrBadRequest, "invalid link")
+	var better pkg.Better
+
+	if err := s.DB.Gorm.Where("LOWER(email) = ?", normalizeEmail(data.Email)).Find(&better).Error; err != nil {
+		return nil, errors.Wrapf(pkg.ErrBadRequest, "could not find better with email %s", data.Email)
 	}
 
-	if better.LinkID.String != linkID {
-		return "", errors.Wrap(pkg.ErrBadRequest, "invalid link")
+	// Clear the link only if it's still the latest link sent to the better so
+	// the same link can't be used twice, not even concurrently.
+	r := s.DB.Gorm.Model(&pkg.Better{}).
+		Where("id = ? AND link_id = ?", better.ID, data.LinkID).
+		Updates(map[string]interface{}{
+			"link_id":      nil,
+			"link_sent_at": nil,
+		})
+
+	if r.Error != nil {
+		return nil, errors.Wrap(r.Error, "could not invalidate link")
 	}
 
-	if better.LinkSentAt.Time.Before(time.Now().Add(-4 * time.Hour)) {
-		return "", errors.Wrap(pkg.ErrBadRequest, "link has expired")
+	if r.RowsAffected == 0 {
+		return nil, errors.Wrap(pkg.ErrBadRequest, "invalid link")
 	}
 
-	return s.JWTForBetter(ctx, &better)
+	return s.signIn(ctx, &better)
 }
 
-// JWTForBetter will create a JWT for the passed better.
+// JWTForBetter will create a JWT for the passed better signed with the current
+// signing key.
 func (s *Service) JWTForBetter(ctx context.Context, better *pkg.Better) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, pkg.Claims{
+	key, err := s.Keys.signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.Method, pkg.Claims{
 		StandardClaims: jwt.StandardClaims{
 			NotBefore: time.Now().Unix(),
 			ExpiresAt: time.Now().Add(1 * time.Hour).Unix(),
 			Subject:   better.Name,
 		},
-		ID:    better.ID,
-		Email: better.Email,
-		Image: better.Image.String,
+		ID:      better.ID,
+		Email:   better.Email.String,
+		Image:   better.Image.String,
+		Version: better.TokenVersion,
+		Guest:   int(better.GuestCompetitionID.Int64),
 	})
 
-	return token.SignedString([]byte(HMACSecret))
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.SignKey)
 }
 
-// BetterFromJWT will parse a JWT and return the better it's signed for.
+// BetterFromJWT will parse a JWT and return the better it's signed for. The
+// token must be signed by any of the keys in the key set and the token version
+// must match the current token version for the better, i.e. the better must
+// not have been signed out everywhere or deleted since the token was issued.
 func (s *Service) BetterFromJWT(ctx context.Context, tokenString string) (*pkg.Better, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &pkg.Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-		}
-
-		return []byte(HMACSecret), nil
-	})
+	claims, err := s.Keys.parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
 
+	version, err := s.tokenVersion(claims.ID)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not parse token")
+		return nil, err
 	}
 
-	claims, ok := token.Claims.(*pkg.Claims)
-	if !(ok && token.Valid) {
-		return nil, errors.New("could not get token claims")
+	if claims.Version != version {
+		return nil, errors.Wrap(pkg.ErrUnauthorized, "token has been revoked")
 	}
 
 	return &pkg.Better{
-		ID:    claims.ID,
-		Name:  claims.Subject,
-		Email: claims.Email,
-		Image: null.StringFrom(claims.Image),
+		ID:                 claims.ID,
+		Name:               claims.Subject,
+		Email:              null.NewString(claims.Email, claims.Email != ""),
+		Image:              null.StringFrom(claims.Image),
+		GuestCompetitionID: null.NewInt(int64(claims.Guest), claims.Guest != 0),
 	}, nil
 }
+
+// normalizeEmail returns the email the way it's stored and compared. Emails are
+// case insensitive but the database collation isn't so they're lowercased.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
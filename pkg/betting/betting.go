@@ -3,7 +3,9 @@ package betting
 import (
 	"context"
 	"strings"
+	"time"
 
+	"github.com/guregu/null"
 	"github.com/pkg/errors"
 
 	"github.com/bombsimon/team-betting/pkg"
@@ -13,38 +15,99 @@ import (
 // Service represents a service and implementation of the team betting
 // interface.
 type Service struct {
-	DB          *pkg.Database
-	MailService pkg.MailService
+	DB              *pkg.Database
+	MailService     pkg.MailService
+	Keys            *KeySet
+	RefreshTokenTTL time.Duration
+	LinkSecret      []byte
+	LinkTTL         time.Duration
+	OIDC            *OIDCProvider
+	MaxGuests       int
+
+	versions tokenVersionCache
 }
 
 // AddCompetition will add a new competition.
 func (s *Service) AddCompetition(ctx context.Context, competition *pkg.Competition) (*pkg.Competition, error) {
+	if err := requireScope(ctx, pkg.ScopeAdmin, "add competitions"); err != nil {
+		return nil, err
+	}
+
+	if err := requireGuestAccess(ctx, 0, "add competitions"); err != nil {
+		return nil, err
+	}
+
 	if err := competition.Validate(); err != nil {
 		return nil, errors.Wrap(err, "bad request")
 	}
 
 	cleaned := pkg.Competition{
-		CreatedByID: competition.CreatedByID,
-		Name:        competition.Name,
-		Description: competition.Description,
-		Image:       competition.Image,
-		MinScore:    competition.MinScore,
-		MaxScore:    competition.MaxScore,
+		CreatedByID:   competition.CreatedByID,
+		Name:          competition.Name,
+		Description:   competition.Description,
+		Image:         competition.Image,
+		MinScore:      competition.MinScore,
+		MaxScore:      competition.MaxScore,
+		ScoringRule:   competition.ScoringRule,
+		BetVisibility: competition.BetVisibility,
+		OpensAt:       competition.OpensAt,
+		LockAt:        competition.LockAt,
 	}
 
 	if cleaned.MaxScore == 0 {
 		cleaned.MaxScore = 10
 	}
 
-	if err := s.DB.Gorm.Save(&cleaned).Error; err != nil {
-		return nil, errors.Wrap(err, "could not create competition")
+	if cleaned.ScoringRule == "" {
+		cleaned.ScoringRule = pkg.ScoringDefault
+	}
+
+	if cleaned.BetVisibility == "" {
+		cleaned.BetVisibility = pkg.BetVisibilityAlways
+	}
+
+	// The code is unique so in the unlikely event that we generate a code
+	// already in use we just try again with a new one.
+	for attempt := 1; ; attempt++ {
+		code, err := generateCode()
+		if err != nil {
+			return nil, err
+		}
+
+		cleaned.Code = null.StringFrom(code)
+
+		err = s.DB.Gorm.Save(&cleaned).Error
+		if err == nil {
+			break
+		}
+
+		if database.ErrType(err) != database.ErrDuplicateKey || attempt >= maxCodeAttempts {
+			return nil, errors.Wrap(err, "could not create competition")
+		}
+
+		cleaned.ID = 0
+	}
+
+	// The creator is always a member of the competition.
+	if _, err := s.addMember(cleaned.ID, cleaned.CreatedByID, pkg.RoleOwner); err != nil {
+		return nil, err
 	}
 
 	return &cleaned, nil
 }
 
 // AddCompetitor will add a new competitor that may be bound to a competition.
+// Competitors may not be bound to a locked competition unless the owner
+// overrides the lock.
 func (s *Service) AddCompetitor(ctx context.Context, competitor *pkg.Competitor, bindToCompetitionID *int) (*pkg.Competitor, error) {
+	if err := requireScope(ctx, pkg.ScopeAdmin, "add competitors"); err != nil {
+		return nil, err
+	}
+
+	if err := requireGuestAccess(ctx, 0, "add competitors"); err != nil {
+		return nil, err
+	}
+
 	if err := competitor.Validate(); err != nil {
 		return nil, errors.Wrap(err, "bad request")
 	}
@@ -56,16 +119,30 @@ func (s *Service) AddCompetitor(ctx context.Context, competitor *pkg.Competitor,
 		Image:       competitor.Image,
 	}
 
-	if err := s.DB.Gorm.Save(&cleaned).Error; err != nil {
-		return nil, errors.Wrap(err, "could not create competitor")
-	}
+	var competition *pkg.Competition
 
 	if bindToCompetitionID != nil {
-		competition, err := s.GetCompetition(ctx, *bindToCompetitionID)
+		c, err := s.getCompetition(*bindToCompetitionID)
 		if err != nil {
 			return nil, errors.Wrap(err, "could not find competition to bind to competitor to")
 		}
 
+		if err := requireRole(ctx, c, "add competitors", pkg.RoleOwner, pkg.RoleCoHost); err != nil {
+			return nil, err
+		}
+
+		if _, err := requireUnlocked(ctx, c, "add competitors"); err != nil {
+			return nil, err
+		}
+
+		competition = c
+	}
+
+	if err := s.DB.Gorm.Save(&cleaned).Error; err != nil {
+		return nil, errors.Wrap(err, "could not create competitor")
+	}
+
+	if competition != nil {
 		if err := s.DB.Gorm.Model(competition).Association("Competitors").Append(&cleaned).Error; err != nil {
 			return nil, errors.Wrap(err, "could not link competitor to competition")
 		}
@@ -75,9 +152,9 @@ func (s *Service) AddCompetitor(ctx context.Context, competitor *pkg.Competitor,
 }
 
 // AddBetter will add a new better that may place bets.
-func (s *Service) AddBetter(ctx context.Context, better *pkg.Better) (string, error) {
+func (s *Service) AddBetter(ctx context.Context, better *pkg.Better) (*pkg.Tokens, error) {
 	if err := better.Validate(); err != nil {
-		return "", errors.Wrap(err, "bad request")
+		return nil, errors.Wrap(err, "bad request")
 	}
 
 	cleaned := pkg.Better{
@@ -86,29 +163,41 @@ func (s *Service) AddBetter(ctx context.Context, better *pkg.Better) (string, er
 		Image: better.Image,
 	}
 
+	if cleaned.Email.Valid {
+		cleaned.Email.String = normalizeEmail(cleaned.Email.String)
+	}
+
 	if err := s.DB.Gorm.Save(&cleaned).Error; err != nil {
 		if database.ErrType(err) == database.ErrDuplicateKey {
-			return "", errors.New("a user with that email already exist")
+			return nil, errors.New("a user with that email already exist")
 		}
 
-		return "", errors.Wrap(err, "could not create competitor")
+		return nil, errors.Wrap(err, "could not create competitor")
 	}
 
-	signedToken, err := s.JWTForBetter(ctx, &cleaned)
+	tokens, err := s.signIn(ctx, &cleaned)
 	if err != nil {
-		return "", errors.Wrap(err, "could not create JWT for new user")
+		return nil, errors.Wrap(err, "could not sign in new user")
 	}
 
-	return signedToken, nil
+	return tokens, nil
 }
 
 // AddBet will add a bet for a better to a competitor in a competition.
 func (s *Service) AddBet(ctx context.Context, bet *pkg.Bet) (*pkg.Bet, error) {
+	if err := requireScope(ctx, pkg.ScopeBet, "add bets"); err != nil {
+		return nil, err
+	}
+
 	if err := bet.ValidateInit(); err != nil {
 		return nil, errors.Wrap(err, "bad request")
 	}
 
-	competition, err := s.GetCompetition(ctx, bet.CompetitionID)
+	if err := requireGuestAccess(ctx, bet.CompetitionID, "bet in other competitions"); err != nil {
+		return nil, err
+	}
+
+	competition, err := s.getCompetition(bet.CompetitionID)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not find competition to add bet to")
 	}
@@ -117,6 +206,16 @@ func (s *Service) AddBet(ctx context.Context, bet *pkg.Bet) (*pkg.Bet, error) {
 		return nil, errors.Wrap(err, "bad request")
 	}
 
+	override, err := requireOpen(ctx, competition, "add bets")
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the owner correcting bets may place bets for other betters.
+	if better, ok := pkg.BetterFromContext(ctx); ok && better.ID != bet.BetterID && !override {
+		return nil, errors.Wrap(pkg.ErrForbidden, "may not add bets for other betters")
+	}
+
 	// Ensure the competitor actually competes in the competition.
 	r := s.DB.Gorm.
 		Model(&pkg.Competition{ID: bet.CompetitionID}).
@@ -139,7 +238,9 @@ func (s *Service) AddBet(ctx context.Context, bet *pkg.Bet) (*pkg.Bet, error) {
 		Note:    bet.Note,
 	}
 
-	result := s.DB.Gorm.Where(where).
+	tx := s.DB.Gorm.Begin()
+
+	result := tx.Where(where).
 		Assign(pkg.Bet{
 			Placing: cleaned.Placing,
 			Score:   cleaned.Score,
@@ -148,9 +249,21 @@ func (s *Service) AddBet(ctx context.Context, bet *pkg.Bet) (*pkg.Bet, error) {
 		FirstOrCreate(&cleaned)
 
 	if result.Error != nil {
+		tx.Rollback()
 		return nil, errors.Wrap(result.Error, "could not create or update bet")
 	}
 
+	if override {
+		if err := audit(ctx, tx, bet.CompetitionID, pkg.AuditBetOverride, null.IntFrom(int64(cleaned.ID)), auditBet(&cleaned)); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, errors.Wrap(err, "could not create or update bet")
+	}
+
 	// Ensure fields are non-nil when inflating.
 	cleaned.Better = &pkg.Better{}
 	cleaned.Competitor = &pkg.Competitor{}
@@ -162,7 +275,8 @@ func (s *Service) AddBet(ctx context.Context, bet *pkg.Bet) (*pkg.Bet, error) {
 	return &cleaned, nil
 }
 
-// GetCompetition will return a competition based on a competition ID.
+// GetCompetition will return a competition based on a competition ID. Bets the
+// better making the request may not see yet are hidden.
 func (s *Service) GetCompetition(ctx context.Context, competitionID int) (*pkg.Competition, error) {
 	c, err := s.GetCompetitions(ctx, []int{competitionID})
 	if err != nil {
@@ -177,7 +291,36 @@ func (s *Service) GetCompetition(ctx context.Context, competitionID int) (*pkg.C
 }
 
 // GetCompetitions will return a list of competition based on competition IDs.
+// Bets the better making the request may not see yet are hidden.
 func (s *Service) GetCompetitions(ctx context.Context, competitionIDs []int) ([]*pkg.Competition, error) {
+	competitions, err := s.getCompetitions(competitionIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.hideCompetitionBets(ctx, competitions); err != nil {
+		return nil, err
+	}
+
+	return competitions, nil
+}
+
+// getCompetition will return a competition with all bets, including bets the
+// better making the request may not see yet.
+func (s *Service) getCompetition(competitionID int) (*pkg.Competition, error) {
+	c, err := s.getCompetitions([]int{competitionID})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(c) != 1 {
+		return nil, errors.Wrap(pkg.ErrNotFound, "no competition found")
+	}
+
+	return c[0], nil
+}
+
+func (s *Service) getCompetitions(competitionIDs []int) ([]*pkg.Competition, error) {
 	var competitions []*pkg.Competition
 
 	q := s.DB.Gorm
@@ -188,6 +331,7 @@ func (s *Service) GetCompetitions(ctx context.Context, competitionIDs []int) ([]
 	err := q.
 		Preload("CreatedBy").
 		Preload("Competitors").
+		Preload("Members.Better").
 		Preload("Bets.Better").
 		Preload("Bets.Competitor").
 		Find(&competitions).
@@ -287,16 +431,29 @@ func (s *Service) GetBets(ctx context.Context, betIDs []int) ([]*pkg.Bet, error)
 		return nil, errors.Wrap(err, "could not get bets")
 	}
 
+	if err := s.hideBets(ctx, bets); err != nil {
+		return nil, err
+	}
+
 	return bets, nil
 }
 
-// DeleteCompetition will delete a competition
+// DeleteCompetition will delete a competition. Only the owner of the
+// competition may delete it.
 func (s *Service) DeleteCompetition(ctx context.Context, id int) error {
-	c, err := s.GetCompetition(ctx, id)
+	if err := requireScope(ctx, pkg.ScopeAdmin, "delete competitions"); err != nil {
+		return err
+	}
+
+	c, err := s.getCompetition(id)
 	if err != nil {
 		return err
 	}
 
+	if err := requireRole(ctx, c, "delete the competition", pkg.RoleOwner); err != nil {
+		return err
+	}
+
 	if err := s.DB.Gorm.Delete(c).Error; err != nil {
 		return errors.Wrap(err, "could not delete competition")
 	}
@@ -304,13 +461,22 @@ func (s *Service) DeleteCompetition(ctx context.Context, id int) error {
 	return nil
 }
 
-// DeleteCompetitor will delete a competitor.
+// DeleteCompetitor will delete a competitor. Only the creator of the
+// competitor may delete it.
 func (s *Service) DeleteCompetitor(ctx context.Context, id int) error {
+	if err := requireScope(ctx, pkg.ScopeAdmin, "delete competitors"); err != nil {
+		return err
+	}
+
 	c, err := s.GetCompetitor(ctx, id)
 	if err != nil {
 		return err
 	}
 
+	if err := requireOwner(ctx, c.CreatedByID, "delete the competitor"); err != nil {
+		return err
+	}
+
 	if err := s.DB.Gorm.Delete(c).Error; err != nil {
 		return errors.Wrap(err, "could not delete competitor")
 	}
@@ -318,28 +484,77 @@ func (s *Service) DeleteCompetitor(ctx context.Context, id int) error {
 	return nil
 }
 
-// DeleteBetter will delete a better.
+// DeleteBetter will delete a better. A better may only delete themselves.
 func (s *Service) DeleteBetter(ctx context.Context, id int) error {
+	if err := requireSession(ctx, "delete betters"); err != nil {
+		return err
+	}
+
 	b, err := s.GetBetter(ctx, id)
 	if err != nil {
 		return err
 	}
 
+	if err := requireOwner(ctx, b.ID, "delete the better"); err != nil {
+		return err
+	}
+
 	if err := s.DB.Gorm.Delete(b).Error; err != nil {
 		return errors.Wrap(err, "could not delete better")
 	}
 
+	// Tokens issued to a deleted better are no longer valid.
+	s.versions.invalidate(b.ID)
+
 	return nil
 }
 
-// DeleteBet will delete a bet.
+// DeleteBet will delete a bet. Only the better who placed the bet may delete
+// it.
 func (s *Service) DeleteBet(ctx context.Context, id int) error {
-	b, err := s.GetBet(ctx, id)
+	if err := requireScope(ctx, pkg.ScopeBet, "delete bets"); err != nil {
+		return err
+	}
+
+	// The bet isn't hidden since the owner may correct bets of other betters.
+	b := &pkg.Bet{}
+
+	if s.DB.Gorm.First(b, id).RecordNotFound() {
+		return errors.Wrap(pkg.ErrNotFound, "no bet found")
+	}
+
+	competition, err := s.getCompetition(b.CompetitionID)
 	if err != nil {
 		return err
 	}
 
-	if err := s.DB.Gorm.Delete(b).Error; err != nil {
+	override, err := requireOpen(ctx, competition, "delete bets")
+	if err != nil {
+		return err
+	}
+
+	// The owner correcting bets may delete bets for other betters.
+	if !override {
+		if err := requireOwner(ctx, b.BetterID, "delete the bet"); err != nil {
+			return err
+		}
+	}
+
+	tx := s.DB.Gorm.Begin()
+
+	if err := tx.Delete(b).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "could not delete bet")
+	}
+
+	if override {
+		if err := audit(ctx, tx, b.CompetitionID, pkg.AuditBetDeleteOverride, null.IntFrom(int64(b.ID)), auditBet(b)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
 		return errors.Wrap(err, "could not delete bet")
 	}
 
@@ -370,6 +585,10 @@ func (s *Service) GetBetsForCompetition(ctx context.Context, competitionID int)
 		return nil, errors.Wrap(err, "could not get bets")
 	}
 
+	if err := s.hideBets(ctx, bets); err != nil {
+		return nil, err
+	}
+
 	return bets, nil
 }
 
@@ -394,36 +613,52 @@ func (s *Service) GetCreatedObjectsForBetter(ctx context.Context, id int) ([]*pk
 		return nil, nil, nil, errors.Wrap(err, "could not get bets for better")
 	}
 
+	if err := s.hideBets(ctx, bets); err != nil {
+		return nil, nil, nil, err
+	}
+
 	return competitions, competitors, bets, nil
 }
 
-// LockCompetition takes the final result and locks a competition.
+// LockCompetition takes the final result and locks a competition. Only the
+// owner and co-hosts of the competition may lock it.
 func (s *Service) LockCompetition(ctx context.Context, id int) error {
-	c, err := s.GetCompetition(ctx, id)
-	if err != nil {
+	if err := requireScope(ctx, pkg.ScopeAdmin, "lock competitions"); err != nil {
 		return err
 	}
 
-	if c.Locked {
-		return errors.Wrap(pkg.ErrBadRequest, "competition already locked")
+	c, err := s.getCompetition(id)
+	if err != nil {
+		return err
 	}
 
-	c.Locked = true
+	if err := requireRole(ctx, c, "lock the competition", pkg.RoleOwner, pkg.RoleCoHost); err != nil {
+		return err
+	}
 
-	if err := s.DB.Gorm.Save(c).Error; err != nil {
-		return errors.Wrap(err, "could not lock competition")
+	if c.Locked {
+		return errors.Wrap(pkg.ErrBadRequest, "competition already locked")
 	}
 
-	return nil
+	return s.setLocked(ctx, id, true, pkg.AuditLocked)
 }
 
-// SetCompetitionResult will set the result for a competition.
+// SetCompetitionResult will set the result for a competition. Only the owner
+// and co-hosts of the competition may set the result.
 func (s *Service) SetCompetitionResult(ctx context.Context, id int, result []*pkg.Result) (*pkg.CompetitionMetrics, error) {
-	c, err := s.GetCompetition(ctx, id)
+	if err := requireScope(ctx, pkg.ScopeAdmin, "set results"); err != nil {
+		return nil, err
+	}
+
+	c, err := s.getCompetition(id)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := requireRole(ctx, c, "set the result", pkg.RoleOwner, pkg.RoleCoHost); err != nil {
+		return nil, err
+	}
+
 	if !c.Locked {
 		return nil, errors.Wrap(pkg.ErrBadRequest, "competition not locked")
 	}
@@ -458,3 +693,39 @@ func (s *Service) SetCompetitionResult(ctx context.Context, id int, result []*pk
 
 	return s.GetCompetitionMetrics(ctx, id)
 }
+
+// ClearCompetitionResult will remove the result for a competition, e.g. to be
+// able to unlock it. Only the owner and co-hosts of the competition may clear
+// the result.
+func (s *Service) ClearCompetitionResult(ctx context.Context, id int) error {
+	if err := requireScope(ctx, pkg.ScopeAdmin, "clear results"); err != nil {
+		return err
+	}
+
+	c, err := s.getCompetition(id)
+	if err != nil {
+		return err
+	}
+
+	if err := requireRole(ctx, c, "clear the result", pkg.RoleOwner, pkg.RoleCoHost); err != nil {
+		return err
+	}
+
+	tx := s.DB.Gorm.Begin()
+
+	if err := tx.Where("competition_id = ?", id).Delete(&pkg.Result{}).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "could not clear result")
+	}
+
+	if err := audit(ctx, tx, id, pkg.AuditResultCleared, null.Int{}, nil); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.Wrap(err, "could not clear result")
+	}
+
+	return nil
+}
@@ -2,10 +2,29 @@ package betting
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/guregu/null"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -22,24 +41,30 @@ func setupService(t *testing.T) *Service {
 	defer db.DB.Exec("SET FOREIGN_KEY_CHECKS=1")
 
 	for _, tbl := range []string{
+		pkg.APITokenTable,
+		pkg.AuditEntryTable,
 		pkg.BetTable,
 		pkg.BetterTable,
 		pkg.CompetitionCompetitorTable,
+		pkg.CompetitionMemberTable,
 		pkg.CompetitorTable,
 		pkg.CompetitionTable,
+		pkg.RefreshTokenTable,
+		pkg.ResultTable,
 	} {
 		_, err := db.DB.Exec(fmt.Sprintf("TRUNCATE TABLE %s", tbl))
 		require.NoError(t, err)
 	}
 
 	s := &Service{
-		DB: db,
+		DB:   db,
+		Keys: NewKeySet(NewHMACKey("unittest", []byte("s3cr3t"))),
 	}
 
 	// Ensure there's always at least one better.
 	_, err := s.AddBetter(context.Background(), &pkg.Better{
 		Name:  "Unittest better",
-		Email: "user@iamveryunique.se",
+		Email: null.StringFrom("user@iamveryunique.se"),
 	})
 
 	require.NoError(t, err)
@@ -57,6 +82,10 @@ func (s *Service) anyBetter() *pkg.Better {
 	return &b
 }
 
+func (s *Service) anyBetterContext() context.Context {
+	return pkg.ContextWithBetter(context.Background(), s.anyBetter())
+}
+
 func TestService_AddCompetition(t *testing.T) {
 	s := setupService(t)
 
@@ -168,7 +197,7 @@ func TestService_AddBetter(t *testing.T) {
 			description: "invalid email",
 			better: &pkg.Better{
 				Name:  "Unittest better",
-				Email: "zzz",
+				Email: null.StringFrom("zzz"),
 			},
 			errContains: "bad request: email: must be a valid email address.",
 		},
@@ -176,14 +205,14 @@ func TestService_AddBetter(t *testing.T) {
 			description: "successful create",
 			better: &pkg.Better{
 				Name:  "Unittest better",
-				Email: "unit@test.se",
+				Email: null.StringFrom("unit@test.se"),
 			},
 		},
 		{
 			description: "cannot add user with same email",
 			better: &pkg.Better{
 				Name:  "Unittest better",
-				Email: "unit@test.se",
+				Email: null.StringFrom("unit@test.se"),
 			},
 			errContains: "a user with that email already exist",
 		},
@@ -197,7 +226,7 @@ func TestService_AddBetter(t *testing.T) {
 
 			if tc.errContains != "" {
 				require.Error(t, err)
-				require.Nil(t, b)
+				require.Empty(t, b)
 				assert.Contains(t, err.Error(), tc.errContains)
 
 				return
@@ -209,6 +238,24 @@ func TestService_AddBetter(t *testing.T) {
 	}
 }
 
+func TestService_AddBetter_Token(t *testing.T) {
+	s := setupService(t)
+
+	tokens, err := s.AddBetter(context.Background(), &pkg.Better{
+		Name:  "Unittest better",
+		Email: null.StringFrom("unit@test.se"),
+	})
+
+	require.NoError(t, err)
+
+	better, err := s.BetterFromJWT(context.Background(), tokens.JWT)
+
+	require.NoError(t, err)
+	assert.NotZero(t, better.ID)
+	assert.Equal(t, "Unittest better", better.Name)
+	assert.Equal(t, "unit@test.se", better.Email.String)
+}
+
 func TestService_AddBet(t *testing.T) {
 	var (
 		s             = setupService(t)
@@ -231,7 +278,7 @@ func TestService_AddBet(t *testing.T) {
 	require.NoError(t, err)
 
 	for i := range make([]int, 3) {
-		c, err := s.AddCompetitor(context.Background(), &pkg.Competitor{
+		c, err := s.AddCompetitor(s.anyBetterContext(), &pkg.Competitor{
 			CreatedByID: s.anyBetter().ID,
 			Name:        fmt.Sprintf("Unittest competitor %d", i+1),
 		}, &competition.ID)
@@ -241,11 +288,15 @@ func TestService_AddBet(t *testing.T) {
 
 		competitorIDs = append(competitorIDs, c.ID)
 
-		b, err := s.AddBetter(context.Background(), &pkg.Better{
+		tokens, err := s.AddBetter(context.Background(), &pkg.Better{
 			Name:  fmt.Sprintf("Unittest better %d", i+1),
-			Email: fmt.Sprintf("user%d@test.se", i+1),
+			Email: null.StringFrom(fmt.Sprintf("user%d@test.se", i+1)),
 		})
 
+		require.NoError(t, err)
+
+		b, err := s.BetterFromJWT(context.Background(), tokens.JWT)
+
 		require.NoError(t, err)
 		require.NotNil(t, b)
 
@@ -350,7 +401,7 @@ func TestPreloadMany2Many(t *testing.T) {
 	require.NoError(t, err)
 
 	for i := range make([]int, 3) {
-		_, err := s.AddCompetitor(context.Background(), &pkg.Competitor{
+		_, err := s.AddCompetitor(s.anyBetterContext(), &pkg.Competitor{
 			CreatedByID: s.anyBetter().ID,
 			Name:        fmt.Sprintf("Unittest competitor %d", i+1),
 		}, &competition.ID)
@@ -379,7 +430,7 @@ func TestGetCompetitionMetrics(t *testing.T) {
 	require.NoError(t, err)
 
 	for i := range make([]int, 3) {
-		c, err := s.AddCompetitor(context.Background(), &pkg.Competitor{
+		c, err := s.AddCompetitor(s.anyBetterContext(), &pkg.Competitor{
 			CreatedByID: s.anyBetter().ID,
 			Name:        fmt.Sprintf("Unittest competitor %d", i+1),
 		}, &competition.ID)
@@ -389,11 +440,15 @@ func TestGetCompetitionMetrics(t *testing.T) {
 
 		competitorIDs = append(competitorIDs, c.ID)
 
-		b, err := s.AddBetter(context.Background(), &pkg.Better{
+		tokens, err := s.AddBetter(context.Background(), &pkg.Better{
 			Name:  fmt.Sprintf("Unittest better %d", i+1),
-			Email: fmt.Sprintf("user%d@test.se", i+1),
+			Email: null.StringFrom(fmt.Sprintf("user%d@test.se", i+1)),
 		})
 
+		require.NoError(t, err)
+
+		b, err := s.BetterFromJWT(context.Background(), tokens.JWT)
+
 		require.NoError(t, err)
 		require.NotNil(t, b)
 
@@ -444,3 +499,1671 @@ func TestGetCompetitionMetrics(t *testing.T) {
 	assert.Equal(t, m.NumberOfTopScores, 1)
 	assert.Equal(t, m.GroupAverageScore, float64(5))
 }
+
+func TestLeaderboard(t *testing.T) {
+	var (
+		alice = &pkg.Better{ID: 1, Name: "Alice"}
+		bob   = &pkg.Better{ID: 2, Name: "Bob"}
+		carol = &pkg.Better{ID: 3, Name: "Carol"}
+	)
+
+	results := []*pkg.Result{
+		{CompetitorID: 1, Placing: 1},
+		{CompetitorID: 2, Placing: 2},
+		{CompetitorID: 3, Placing: 3},
+	}
+
+	bets := []*pkg.Bet{
+		// Alice places everyone correct.
+		{Better: alice, BetterID: alice.ID, CompetitorID: 1, Placing: null.IntFrom(1)},
+		{Better: alice, BetterID: alice.ID, CompetitorID: 2, Placing: null.IntFrom(2)},
+		{Better: alice, BetterID: alice.ID, CompetitorID: 3, Placing: null.IntFrom(3)},
+
+		// Bob only scores but his scores implies the correct order.
+		{Better: bob, BetterID: bob.ID, CompetitorID: 1, Score: null.IntFrom(9)},
+		{Better: bob, BetterID: bob.ID, CompetitorID: 2, Score: null.IntFrom(5)},
+		{Better: bob, BetterID: bob.ID, CompetitorID: 3, Score: null.IntFrom(1)},
+
+		// Carol got it all backwards.
+		{Better: carol, BetterID: carol.ID, CompetitorID: 1, Placing: null.IntFrom(3)},
+		{Better: carol, BetterID: carol.ID, CompetitorID: 2, Placing: null.IntFrom(2)},
+		{Better: carol, BetterID: carol.ID, CompetitorID: 3, Placing: null.IntFrom(1)},
+	}
+
+	entries := leaderboard(bets, results, &DistanceRule{})
+
+	require.Len(t, entries, 3)
+
+	assert.Equal(t, "Alice", entries[0].Better.Name)
+	assert.Equal(t, 1, entries[0].Rank)
+	assert.Equal(t, float64(9), entries[0].Points)
+	assert.Equal(t, 3, entries[0].ExactHits)
+
+	assert.Equal(t, "Bob", entries[1].Better.Name)
+	assert.Equal(t, 1, entries[1].Rank)
+	assert.Equal(t, float64(9), entries[1].Points)
+
+	assert.Equal(t, "Carol", entries[2].Better.Name)
+	assert.Equal(t, 3, entries[2].Rank)
+	assert.Equal(t, float64(5), entries[2].Points)
+	assert.Equal(t, 1, entries[2].ExactHits)
+}
+
+func TestScoringRules(t *testing.T) {
+	var (
+		actual   = map[int]int{1: 1, 2: 2, 3: 3, 4: 4}
+		perfect  = map[int]int{1: 1, 2: 2, 3: 3, 4: 4}
+		reversed = map[int]int{1: 4, 2: 3, 3: 2, 4: 1}
+		swapped  = map[int]int{1: 2, 2: 1, 3: 3, 4: 4}
+	)
+
+	cases := []struct {
+		rule      string
+		predicted map[int]int
+		expected  float64
+	}{
+		{rule: pkg.ScoringExact, predicted: perfect, expected: 4},
+		{rule: pkg.ScoringExact, predicted: reversed, expected: 0},
+		{rule: pkg.ScoringExact, predicted: swapped, expected: 2},
+		{rule: pkg.ScoringDistance, predicted: perfect, expected: 16},
+		{rule: pkg.ScoringDistance, predicted: reversed, expected: 8},
+		{rule: pkg.ScoringDistance, predicted: swapped, expected: 14},
+		{rule: pkg.ScoringEurovision, predicted: perfect, expected: 37},
+		{rule: pkg.ScoringEurovision, predicted: reversed, expected: 30},
+		{rule: pkg.ScoringEurovision, predicted: swapped, expected: 35},
+		{rule: pkg.ScoringSpearman, predicted: perfect, expected: 1},
+		{rule: pkg.ScoringSpearman, predicted: reversed, expected: -1},
+		{rule: pkg.ScoringSpearman, predicted: swapped, expected: 0.8},
+		{rule: pkg.ScoringKendall, predicted: perfect, expected: 1},
+		{rule: pkg.ScoringKendall, predicted: reversed, expected: -1},
+		{rule: pkg.ScoringKendall, predicted: swapped, expected: float64(4) / 6},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.rule, func(t *testing.T) {
+			rule, err := ScoringRuleFor(tc.rule)
+
+			require.NoError(t, err)
+			assert.InDelta(t, tc.expected, rule.Score(tc.predicted, actual), 0.0001)
+		})
+	}
+
+	_, err := ScoringRuleFor("unknown")
+	assert.Error(t, err)
+}
+
+func TestService_JoinCompetition(t *testing.T) {
+	s := setupService(t)
+
+	competition, err := s.AddCompetition(context.Background(), &pkg.Competition{
+		CreatedByID: s.anyBetter().ID,
+		Name:        "Unittest lobby",
+	})
+
+	require.NoError(t, err)
+	require.True(t, competition.Code.Valid)
+	require.Len(t, competition.Code.String, codeLength)
+
+	tokens, err := s.AddBetter(context.Background(), &pkg.Better{
+		Name:  "Unittest joiner",
+		Email: null.StringFrom("joiner@test.se"),
+	})
+
+	require.NoError(t, err)
+
+	b, err := s.BetterFromJWT(context.Background(), tokens.JWT)
+
+	require.NoError(t, err)
+
+	_, err = s.JoinCompetition(context.Background(), "nope", b.ID)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no competition found")
+
+	// Joining twice should not add the better twice.
+	for range make([]int, 2) {
+		c, err := s.JoinCompetition(context.Background(), strings.ToLower(competition.Code.String), b.ID)
+
+		require.NoError(t, err)
+		assert.Equal(t, competition.ID, c.ID)
+		assert.Len(t, c.Members, 2)
+	}
+}
+
+func TestService_OwnerAuthorization(t *testing.T) {
+	s := setupService(t)
+
+	owner := s.anyBetter()
+
+	tokens, err := s.AddBetter(context.Background(), &pkg.Better{
+		Name:  "Unittest intruder",
+		Email: null.StringFrom("intruder@test.se"),
+	})
+
+	require.NoError(t, err)
+
+	intruder, err := s.BetterFromJWT(context.Background(), tokens.JWT)
+
+	require.NoError(t, err)
+
+	var (
+		ownerCtx    = pkg.ContextWithBetter(context.Background(), owner)
+		intruderCtx = pkg.ContextWithBetter(context.Background(), intruder)
+	)
+
+	competition, err := s.AddCompetition(ownerCtx, &pkg.Competition{
+		CreatedByID: owner.ID,
+		Name:        "Unittest competition",
+	})
+
+	require.NoError(t, err)
+
+	for _, ctx := range []context.Context{context.Background(), intruderCtx} {
+		err = s.LockCompetition(ctx, competition.ID)
+		assert.Equal(t, pkg.ErrForbidden, errors.Cause(err))
+
+		err = s.DeleteCompetition(ctx, competition.ID)
+		assert.Equal(t, pkg.ErrForbidden, errors.Cause(err))
+
+		err = s.DeleteBetter(ctx, owner.ID)
+		assert.Equal(t, pkg.ErrForbidden, errors.Cause(err))
+	}
+
+	require.NoError(t, s.LockCompetition(ownerCtx, competition.ID))
+	require.NoError(t, s.DeleteCompetition(ownerCtx, competition.ID))
+	require.NoError(t, s.DeleteBetter(intruderCtx, intruder.ID))
+}
+
+func TestService_MemberRoles(t *testing.T) {
+	s := setupService(t)
+
+	owner := s.anyBetter()
+	ownerCtx := s.anyBetterContext()
+
+	competition, err := s.AddCompetition(ownerCtx, &pkg.Competition{
+		CreatedByID: owner.ID,
+		Name:        "Unittest competition",
+	})
+
+	require.NoError(t, err)
+
+	tokens, err := s.AddBetter(context.Background(), &pkg.Better{
+		Name:  "Unittest co-host",
+		Email: null.StringFrom("cohost@test.se"),
+	})
+
+	require.NoError(t, err)
+
+	cohost, err := s.BetterFromJWT(context.Background(), tokens.JWT)
+
+	require.NoError(t, err)
+
+	cohostCtx := pkg.ContextWithBetter(context.Background(), cohost)
+
+	member, err := s.InviteMember(ownerCtx, competition.ID, cohost.ID, "")
+
+	require.NoError(t, err)
+	assert.Equal(t, pkg.RoleParticipant, member.Role)
+
+	// Participants may not add competitors or lock the competition.
+	_, err = s.AddCompetitor(cohostCtx, &pkg.Competitor{
+		CreatedByID: cohost.ID,
+		Name:        "Unittest competitor",
+	}, &competition.ID)
+
+	assert.Equal(t, pkg.ErrForbidden, errors.Cause(err))
+
+	_, err = s.SetMemberRole(cohostCtx, competition.ID, cohost.ID, pkg.RoleCoHost)
+	assert.Equal(t, pkg.ErrForbidden, errors.Cause(err))
+
+	member, err = s.SetMemberRole(ownerCtx, competition.ID, cohost.ID, pkg.RoleCoHost)
+
+	require.NoError(t, err)
+	assert.Equal(t, pkg.RoleCoHost, member.Role)
+
+	_, err = s.AddCompetitor(cohostCtx, &pkg.Competitor{
+		CreatedByID: cohost.ID,
+		Name:        "Unittest competitor",
+	}, &competition.ID)
+
+	require.NoError(t, err)
+	require.NoError(t, s.LockCompetition(cohostCtx, competition.ID))
+
+	// Only the owner may delete the competition or remove members.
+	err = s.DeleteCompetition(cohostCtx, competition.ID)
+	assert.Equal(t, pkg.ErrForbidden, errors.Cause(err))
+
+	err = s.RemoveMember(cohostCtx, competition.ID, owner.ID)
+	assert.Equal(t, pkg.ErrForbidden, errors.Cause(err))
+
+	require.NoError(t, s.RemoveMember(ownerCtx, competition.ID, cohost.ID))
+}
+
+func TestService_RefreshTokens(t *testing.T) {
+	s := setupService(t)
+
+	tokens, err := s.AddBetter(context.Background(), &pkg.Better{
+		Name:  "Unittest refresher",
+		Email: null.StringFrom("refresher@test.se"),
+	})
+
+	require.NoError(t, err)
+	require.NotEmpty(t, tokens.RefreshToken)
+
+	_, err = s.RefreshTokens(context.Background(), "not-a-token")
+	assert.Equal(t, pkg.ErrUnauthorized, errors.Cause(err))
+
+	rotated, err := s.RefreshTokens(context.Background(), tokens.RefreshToken)
+
+	require.NoError(t, err)
+	assert.NotEqual(t, tokens.RefreshToken, rotated.RefreshToken)
+
+	b, err := s.BetterFromJWT(context.Background(), rotated.JWT)
+
+	require.NoError(t, err)
+	assert.Equal(t, "refresher@test.se", b.Email.String)
+
+	// Reusing a rotated token should revoke the whole family, including the
+	// token handed out when rotating.
+	_, err = s.RefreshTokens(context.Background(), tokens.RefreshToken)
+	assert.Equal(t, pkg.ErrUnauthorized, errors.Cause(err))
+
+	_, err = s.RefreshTokens(context.Background(), rotated.RefreshToken)
+	assert.Equal(t, pkg.ErrUnauthorized, errors.Cause(err))
+
+	// Revoking a token (logging out) should make it unusable.
+	other, err := s.signIn(context.Background(), b)
+
+	require.NoError(t, err)
+	require.NoError(t, s.RevokeRefreshToken(context.Background(), other.RefreshToken))
+
+	_, err = s.RefreshTokens(context.Background(), other.RefreshToken)
+	assert.Equal(t, pkg.ErrUnauthorized, errors.Cause(err))
+}
+
+func TestService_SignOutEverywhere(t *testing.T) {
+	s := setupService(t)
+
+	tokens, err := s.AddBetter(context.Background(), &pkg.Better{
+		Name:  "Unittest traveller",
+		Email: null.StringFrom("traveller@test.se"),
+	})
+
+	require.NoError(t, err)
+
+	b, err := s.BetterFromJWT(context.Background(), tokens.JWT)
+
+	require.NoError(t, err)
+
+	ctx := pkg.ContextWithBetter(context.Background(), b)
+
+	apiToken, err := s.CreateAPIToken(ctx, &pkg.APIToken{Name: "Unittest bot", Scope: pkg.ScopeRead})
+
+	require.NoError(t, err)
+
+	err = s.SignOutEverywhere(s.anyBetterContext(), b.ID)
+	assert.Equal(t, pkg.ErrForbidden, errors.Cause(err))
+
+	require.NoError(t, s.SignOutEverywhere(ctx, b.ID))
+
+	_, err = s.BetterFromJWT(context.Background(), tokens.JWT)
+	assert.Equal(t, pkg.ErrUnauthorized, errors.Cause(err))
+
+	_, err = s.RefreshTokens(context.Background(), tokens.RefreshToken)
+	assert.Equal(t, pkg.ErrUnauthorized, errors.Cause(err))
+
+	_, err = s.APITokenFromString(context.Background(), apiToken.Token)
+	assert.Equal(t, pkg.ErrUnauthorized, errors.Cause(err))
+
+	// Tokens issued after signing out should be valid until the better is
+	// deleted.
+	better, err := s.GetBetter(context.Background(), b.ID)
+
+	require.NoError(t, err)
+
+	jwt, err := s.JWTForBetter(context.Background(), better)
+
+	require.NoError(t, err)
+
+	_, err = s.BetterFromJWT(context.Background(), jwt)
+
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteBetter(ctx, b.ID))
+
+	_, err = s.BetterFromJWT(context.Background(), jwt)
+	assert.Equal(t, pkg.ErrUnauthorized, errors.Cause(err))
+}
+
+type mailRecorder struct {
+	sent []*pkg.MailContent
+}
+
+func (m *mailRecorder) SendMail(content *pkg.MailContent) error {
+	m.sent = append(m.sent, content)
+
+	return nil
+}
+
+// lastLink returns the sign in link from the last sent mail.
+func (m *mailRecorder) lastLink() string {
+	body := m.sent[len(m.sent)-1].Body
+	start := strings.Index(body, "signin=") + len("signin=")
+
+	return body[start : start+strings.Index(body[start:], "\"")]
+}
+
+func TestService_SignInFromEmail(t *testing.T) {
+	var (
+		s    = setupService(t)
+		mail = &mailRecorder{}
+		ctx  = context.Background()
+	)
+
+	s.MailService = mail
+	s.LinkSecret = []byte("l1nk")
+
+	better := s.anyBetter()
+
+	require.NoError(t, s.SendSignInEmail(ctx, better.Email.String))
+
+	firstLink := mail.lastLink()
+
+	require.NoError(t, s.SendSignInEmail(ctx, better.Email.String))
+
+	secondLink := mail.lastLink()
+
+	// Requesting a new link invalidates the old one.
+	_, err := s.SignInFromEmail(ctx, firstLink)
+	assert.Equal(t, pkg.ErrBadRequest, errors.Cause(err))
+
+	tokens, err := s.SignInFromEmail(ctx, secondLink)
+
+	require.NoError(t, err)
+	require.NotEmpty(t, tokens.JWT)
+
+	// The link may only be used once.
+	_, err = s.SignInFromEmail(ctx, secondLink)
+	assert.Equal(t, pkg.ErrBadRequest, errors.Cause(err))
+}
+
+func TestSignInLink(t *testing.T) {
+	s := &Service{LinkSecret: []byte("l1nk")}
+
+	link, err := s.signLink(&pkg.SignInData{
+		Email:     "user@test.se",
+		LinkID:    "some-link-id",
+		ExpiresAt: time.Now().Add(s.linkTTL()),
+	})
+
+	require.NoError(t, err)
+
+	data, err := s.parseLink(link)
+
+	require.NoError(t, err)
+	assert.Equal(t, "user@test.se", data.Email)
+	assert.Equal(t, "some-link-id", data.LinkID)
+
+	expired, err := s.signLink(&pkg.SignInData{
+		Email:     "user@test.se",
+		LinkID:    "some-link-id",
+		ExpiresAt: time.Now().Add(-1 * time.Minute),
+	})
+
+	require.NoError(t, err)
+
+	tampered, err := s.signLink(&pkg.SignInData{
+		Email:     "someone@else.se",
+		LinkID:    "some-link-id",
+		ExpiresAt: time.Now().Add(s.linkTTL()),
+	})
+
+	require.NoError(t, err)
+
+	cases := []struct {
+		description string
+		link        string
+		secret      string
+		errContains string
+	}{
+		{
+			description: "expired link",
+			link:        expired,
+			errContains: "link has expired",
+		},
+		{
+			description: "payload from another link",
+			link:        strings.Split(tampered, ".")[0] + "." + strings.Split(link, ".")[1],
+			errContains: "invalid link",
+		},
+		{
+			description: "signed with another secret",
+			link:        link,
+			secret:      "0th3r",
+			errContains: "invalid link",
+		},
+		{
+			description: "not a link",
+			link:        "bm90IGEgbGluaw",
+			errContains: "invalid link",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			parser := s
+			if tc.secret != "" {
+				parser = &Service{LinkSecret: []byte(tc.secret)}
+			}
+
+			_, err := parser.parseLink(tc.link)
+
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.errContains)
+		})
+	}
+}
+
+// mockIssuer is a minimal OpenID Connect provider issuing ID tokens for the
+// authorization code flow with PKCE.
+type mockIssuer struct {
+	*httptest.Server
+
+	t        *testing.T
+	key      *rsa.PrivateKey
+	audience string
+	logins   map[string]mockLogin
+
+	// jwksRequested and jwksRelease, if set, are used to hold the response
+	// for the provider keys until released.
+	jwksRequested chan struct{}
+	jwksRelease   chan struct{}
+}
+
+type mockLogin struct {
+	challenge string
+	nonce     string
+	email     string
+	verified  bool
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	m := &mockIssuer{
+		t:        t,
+		key:      key,
+		audience: "unittest",
+		logins:   map[string]mockLogin{},
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		if m.jwksRelease != nil {
+			m.jwksRequested <- struct{}{}
+			<-m.jwksRelease
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				{
+					"kid": "mock",
+					"kty": "RSA",
+					"use": "sig",
+					"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+				},
+			},
+		})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		login, ok := m.logins[r.FormValue("code")]
+		delete(m.logins, r.FormValue("code"))
+
+		if !ok || pkceChallenge(r.FormValue("code_verifier")) != login.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+
+			return
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":            m.URL,
+			"sub":            "subject-" + login.email,
+			"aud":            []string{m.audience},
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"iat":            time.Now().Unix(),
+			"nonce":          login.nonce,
+			"email":          login.email,
+			"email_verified": login.verified,
+			"name":           "Unittest SSO better",
+		})
+
+		token.Header["kid"] = "mock"
+
+		idToken, err := token.SignedString(key)
+		require.NoError(t, err)
+
+		w.Header().Set("Content-Type", "application/json")
+
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"id_token":     idToken,
+		})
+	})
+
+	m.Server = httptest.NewServer(mux)
+
+	return m
+}
+
+// authorize will act as if the user signed in at the provider and return the
+// authorization code and state passed back to the client.
+func (m *mockIssuer) authorize(authURL, email string, verified bool) (string, string) {
+	u, err := url.Parse(authURL)
+	require.NoError(m.t, err)
+
+	q := u.Query()
+
+	require.Equal(m.t, "S256", q.Get("code_challenge_method"))
+
+	code := fmt.Sprintf("code-%d", len(m.logins)+1)
+	m.logins[code] = mockLogin{
+		challenge: q.Get("code_challenge"),
+		nonce:     q.Get("nonce"),
+		email:     email,
+		verified:  verified,
+	}
+
+	return code, q.Get("state")
+}
+
+// pkceChallenge returns the S256 code challenge for the code verifier.
+func pkceChallenge(verifier string) string {
+	h := sha256.Sum256([]byte(verifier))
+
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+func TestOIDCProvider_SlowKeys(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer issuer.Close()
+
+	ctx := context.Background()
+
+	provider, err := NewOIDCProvider(ctx, &OIDCConfig{
+		Issuer:   issuer.URL,
+		ClientID: "unittest",
+	})
+
+	require.NoError(t, err)
+
+	issuer.jwksRequested = make(chan struct{})
+	issuer.jwksRelease = make(chan struct{})
+
+	authURL, err := provider.authURL()
+	require.NoError(t, err)
+
+	code, state := issuer.authorize(authURL, "sso@test.se", true)
+
+	keyErr := make(chan error, 1)
+
+	go func() {
+		_, err := provider.exchange(ctx, code, state)
+		keyErr <- err
+	}()
+
+	select {
+	case <-issuer.jwksRequested:
+	case err := <-keyErr:
+		t.Fatalf("login completed before fetching keys: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("keys were never fetched")
+	}
+
+	// Starting a new login must not wait for the keys to be fetched.
+	started := make(chan error)
+
+	go func() {
+		_, err := provider.authURL()
+		started <- err
+	}()
+
+	select {
+	case err := <-started:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("starting a login was blocked by fetching keys")
+	}
+
+	close(issuer.jwksRelease)
+
+	require.NoError(t, <-keyErr)
+}
+
+func TestOIDCProvider(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer issuer.Close()
+
+	ctx := context.Background()
+
+	provider, err := NewOIDCProvider(ctx, &OIDCConfig{
+		Issuer:         issuer.URL,
+		ClientID:       "unittest",
+		RedirectURL:    "http://localhost:3000/login",
+		AllowedDomains: []string{"test.se"},
+	})
+
+	require.NoError(t, err)
+
+	cases := []struct {
+		description string
+		email       string
+		unverified  bool
+		audience    string
+		badVerifier bool
+		errContains string
+	}{
+		{
+			description: "verified email in allowed domain",
+			email:       "SSO@test.se",
+		},
+		{
+			description: "unverified email",
+			email:       "sso@test.se",
+			unverified:  true,
+			errContains: "email is not verified",
+		},
+		{
+			description: "domain not allowed",
+			email:       "sso@other.se",
+			errContains: "email domain for sso@other.se is not allowed",
+		},
+		{
+			description: "issued for another client",
+			email:       "sso@test.se",
+			audience:    "someone-else",
+			errContains: "expected audience",
+		},
+		{
+			description: "wrong code verifier",
+			email:       "sso@test.se",
+			badVerifier: true,
+			errContains: "invalid_grant",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			issuer.audience = "unittest"
+			if tc.audience != "" {
+				issuer.audience = tc.audience
+			}
+
+			authURL, err := provider.authURL()
+			require.NoError(t, err)
+
+			code, state := issuer.authorize(authURL, tc.email, !tc.unverified)
+
+			if tc.badVerifier {
+				provider.logins[state].verifier = "not-the-verifier"
+			}
+
+			identity, err := provider.exchange(ctx, code, state)
+
+			if tc.errContains != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.errContains)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, "sso@test.se", identity.Email)
+			assert.Equal(t, "Unittest SSO better", identity.Name)
+
+			// A login can only be completed once.
+			_, err = provider.exchange(ctx, code, state)
+			assert.Equal(t, pkg.ErrBadRequest, errors.Cause(err))
+		})
+	}
+}
+
+func TestOIDCProvider_MaxLogins(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer issuer.Close()
+
+	provider, err := NewOIDCProvider(context.Background(), &OIDCConfig{
+		Issuer:   issuer.URL,
+		ClientID: "unittest",
+	})
+
+	require.NoError(t, err)
+
+	for i := 0; i < oidcMaxLogins; i++ {
+		provider.logins[fmt.Sprintf("state-%d", i)] = &oidcLogin{expiresAt: time.Now().Add(time.Minute)}
+	}
+
+	_, err = provider.authURL()
+	assert.Equal(t, pkg.ErrBadRequest, errors.Cause(err))
+
+	// Expired logins are removed to make room for new logins.
+	provider.logins["state-0"].expiresAt = time.Now().Add(-time.Second)
+
+	_, err = provider.authURL()
+	require.NoError(t, err)
+
+	assert.Len(t, provider.logins, oidcMaxLogins)
+}
+
+func TestService_SignInFromOIDC(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer issuer.Close()
+
+	var (
+		s   = setupService(t)
+		ctx = context.Background()
+		err error
+	)
+
+	s.OIDC, err = NewOIDCProvider(ctx, &OIDCConfig{
+		Issuer:   issuer.URL,
+		ClientID: "unittest",
+	})
+
+	require.NoError(t, err)
+
+	for _, email := range []string{"sso@test.se", s.anyBetter().Email.String} {
+		authURL, err := s.OIDCAuthURL(ctx)
+		require.NoError(t, err)
+
+		code, state := issuer.authorize(authURL, email, true)
+
+		tokens, err := s.SignInFromOIDC(ctx, code, state)
+		require.NoError(t, err)
+
+		b, err := s.BetterFromJWT(ctx, tokens.JWT)
+
+		require.NoError(t, err)
+		assert.Equal(t, email, b.Email.String)
+	}
+
+	// Signing in again should link to the already provisioned better.
+	var count int
+
+	require.NoError(t, s.DB.Gorm.Model(&pkg.Better{}).Where("email = ?", "sso@test.se").Count(&count).Error)
+	assert.Equal(t, 1, count)
+
+	// Someone registering with the email of a better who hasn't signed in yet
+	// must lose access when the better signs in.
+	attackerTokens, err := s.AddBetter(ctx, &pkg.Better{
+		Name:  "Unittest attacker",
+		Email: null.StringFrom("Victim@test.se"),
+	})
+
+	require.NoError(t, err)
+
+	attacker, err := s.BetterFromJWT(ctx, attackerTokens.JWT)
+
+	require.NoError(t, err)
+	assert.Equal(t, "victim@test.se", attacker.Email.String)
+
+	apiToken, err := s.CreateAPIToken(pkg.ContextWithBetter(ctx, attacker), &pkg.APIToken{
+		Name:  "Unittest bot",
+		Scope: pkg.ScopeRead,
+	})
+
+	require.NoError(t, err)
+
+	authURL, err := s.OIDCAuthURL(ctx)
+	require.NoError(t, err)
+
+	code, state := issuer.authorize(authURL, "victim@test.se", true)
+
+	tokens, err := s.SignInFromOIDC(ctx, code, state)
+	require.NoError(t, err)
+
+	victim, err := s.BetterFromJWT(ctx, tokens.JWT)
+
+	require.NoError(t, err)
+	assert.Equal(t, attacker.ID, victim.ID)
+
+	_, err = s.BetterFromJWT(ctx, attackerTokens.JWT)
+	assert.Equal(t, pkg.ErrUnauthorized, errors.Cause(err))
+
+	_, err = s.RefreshTokens(ctx, attackerTokens.RefreshToken)
+	assert.Equal(t, pkg.ErrUnauthorized, errors.Cause(err))
+
+	_, err = s.APITokenFromString(ctx, apiToken.Token)
+	assert.Equal(t, pkg.ErrUnauthorized, errors.Cause(err))
+}
+
+func TestService_APITokens(t *testing.T) {
+	s := setupService(t)
+
+	var (
+		owner    = s.anyBetter()
+		ownerCtx = s.anyBetterContext()
+	)
+
+	_, err := s.CreateAPIToken(ownerCtx, &pkg.APIToken{Name: "bot", Scope: "superuser"})
+	require.Error(t, err)
+
+	readToken, err := s.CreateAPIToken(ownerCtx, &pkg.APIToken{Name: "stats", Scope: pkg.ScopeRead})
+
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(readToken.Token, pkg.APITokenPrefix))
+
+	adminToken, err := s.CreateAPIToken(ownerCtx, &pkg.APIToken{Name: "results", Scope: pkg.ScopeAdmin})
+
+	require.NoError(t, err)
+
+	tokenContext := func(tokenString string) context.Context {
+		token, err := s.APITokenFromString(context.Background(), tokenString)
+		require.NoError(t, err)
+		require.Equal(t, owner.ID, token.Better.ID)
+
+		return pkg.ContextWithScope(pkg.ContextWithBetter(context.Background(), token.Better), token.Scope)
+	}
+
+	var (
+		readCtx  = tokenContext(readToken.Token)
+		adminCtx = tokenContext(adminToken.Token)
+	)
+
+	_, err = s.AddCompetition(readCtx, &pkg.Competition{CreatedByID: owner.ID, Name: "Unittest competition"})
+	assert.Equal(t, pkg.ErrForbidden, errors.Cause(err))
+
+	competition, err := s.AddCompetition(adminCtx, &pkg.Competition{CreatedByID: owner.ID, Name: "Unittest competition"})
+
+	require.NoError(t, err)
+	require.NoError(t, s.LockCompetition(adminCtx, competition.ID))
+
+	// API tokens can't be used to manage API tokens or the better.
+	_, err = s.CreateAPIToken(adminCtx, &pkg.APIToken{Name: "another", Scope: pkg.ScopeAdmin})
+	assert.Equal(t, pkg.ErrForbidden, errors.Cause(err))
+
+	err = s.DeleteBetter(adminCtx, owner.ID)
+	assert.Equal(t, pkg.ErrForbidden, errors.Cause(err))
+
+	tokens, err := s.GetAPITokens(ownerCtx, owner.ID)
+
+	require.NoError(t, err)
+	assert.Len(t, tokens, 2)
+
+	// Streams may be read with any API token.
+	better, err := s.BetterFromToken(context.Background(), readToken.Token)
+
+	require.NoError(t, err)
+	assert.Equal(t, owner.ID, better.ID)
+
+	require.NoError(t, s.RevokeAPIToken(ownerCtx, readToken.ID))
+
+	_, err = s.APITokenFromString(context.Background(), readToken.Token)
+	assert.Equal(t, pkg.ErrUnauthorized, errors.Cause(err))
+
+	_, err = s.BetterFromToken(context.Background(), readToken.Token)
+	assert.Equal(t, pkg.ErrUnauthorized, errors.Cause(err))
+}
+
+func TestRequireScope(t *testing.T) {
+	better := &pkg.Better{ID: 1}
+
+	cases := []struct {
+		description string
+		scope       string
+		required    string
+		forbidden   bool
+	}{
+		{description: "session", scope: "", required: pkg.ScopeAdmin},
+		{description: "same scope", scope: pkg.ScopeBet, required: pkg.ScopeBet},
+		{description: "higher scope", scope: pkg.ScopeAdmin, required: pkg.ScopeBet},
+		{description: "lower scope", scope: pkg.ScopeRead, required: pkg.ScopeBet, forbidden: true},
+		{description: "unknown scope", scope: "unknown", required: pkg.ScopeRead, forbidden: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			ctx := pkg.ContextWithScope(pkg.ContextWithBetter(context.Background(), better), tc.scope)
+
+			err := requireScope(ctx, tc.required, "do it")
+
+			if tc.forbidden {
+				assert.Equal(t, pkg.ErrForbidden, errors.Cause(err))
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestService_GuestBetters(t *testing.T) {
+	var (
+		s     = setupService(t)
+		owner = s.anyBetterContext()
+		ctx   = context.Background()
+	)
+
+	competition, err := s.AddCompetition(owner, &pkg.Competition{
+		CreatedByID: s.anyBetter().ID,
+		Name:        "Unittest party",
+	})
+
+	require.NoError(t, err)
+
+	other, err := s.AddCompetition(owner, &pkg.Competition{
+		CreatedByID: s.anyBetter().ID,
+		Name:        "Unittest other party",
+	})
+
+	require.NoError(t, err)
+
+	competitor, err := s.AddCompetitor(owner, &pkg.Competitor{
+		CreatedByID: s.anyBetter().ID,
+		Name:        "Unittest competitor",
+	}, &competition.ID)
+
+	require.NoError(t, err)
+
+	_, err = s.JoinCompetitionAsGuest(ctx, competition.Code.String, " ")
+	require.Error(t, err)
+
+	tokens, err := s.JoinCompetitionAsGuest(ctx, competition.Code.String, "Unittest guest")
+	require.NoError(t, err)
+
+	guest, err := s.BetterFromJWT(ctx, tokens.JWT)
+
+	require.NoError(t, err)
+	require.Equal(t, int64(competition.ID), guest.GuestCompetitionID.Int64)
+
+	guestCtx := pkg.ContextWithBetter(ctx, guest)
+
+	bet, err := s.AddBet(guestCtx, &pkg.Bet{
+		BetterID:      guest.ID,
+		CompetitionID: competition.ID,
+		CompetitorID:  competitor.ID,
+		Score:         null.IntFrom(5),
+	})
+
+	require.NoError(t, err)
+
+	// Guests are scoped to the competition they joined.
+	_, err = s.JoinCompetition(guestCtx, other.Code.String, guest.ID)
+	assert.Equal(t, pkg.ErrForbidden, errors.Cause(err))
+
+	_, err = s.AddCompetition(guestCtx, &pkg.Competition{CreatedByID: guest.ID, Name: "Guest competition"})
+	assert.Equal(t, pkg.ErrForbidden, errors.Cause(err))
+
+	_, err = s.UpgradeGuest(guestCtx, guest.ID, s.anyBetter().Email.String)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "a user with that email already exist")
+
+	tokens, err = s.UpgradeGuest(guestCtx, guest.ID, "guest@test.se")
+	require.NoError(t, err)
+
+	upgraded, err := s.BetterFromJWT(ctx, tokens.JWT)
+
+	require.NoError(t, err)
+	assert.Equal(t, guest.ID, upgraded.ID)
+	assert.False(t, upgraded.GuestCompetitionID.Valid)
+
+	// The bets are kept and the better is no longer limited to one competition.
+	b, err := s.GetBet(ctx, bet.ID)
+
+	require.NoError(t, err)
+	assert.Equal(t, guest.ID, b.BetterID)
+
+	_, err = s.JoinCompetition(pkg.ContextWithBetter(ctx, upgraded), other.Code.String, upgraded.ID)
+	require.NoError(t, err)
+
+	// Upgraded guests don't count against the number of guests.
+	s.MaxGuests = 1
+
+	_, err = s.JoinCompetitionAsGuest(ctx, competition.Code.String, "Unittest guest 2")
+	require.NoError(t, err)
+
+	_, err = s.JoinCompetitionAsGuest(ctx, competition.Code.String, "Unittest guest 3")
+	assert.Equal(t, pkg.ErrBadRequest, errors.Cause(err), "the competition is full")
+}
+
+func TestService_ScheduledLock(t *testing.T) {
+	s := setupService(t)
+
+	owner := s.anyBetter()
+	ownerCtx := s.anyBetterContext()
+
+	competition, err := s.AddCompetition(ownerCtx, &pkg.Competition{
+		CreatedByID: owner.ID,
+		Name:        "Unittest competition",
+		OpensAt:     null.TimeFrom(time.Now().Add(time.Hour)),
+		LockAt:      null.TimeFrom(time.Now().Add(2 * time.Hour)),
+	})
+
+	require.NoError(t, err)
+
+	competitor, err := s.AddCompetitor(ownerCtx, &pkg.Competitor{
+		CreatedByID: owner.ID,
+		Name:        "Unittest competitor",
+	}, &competition.ID)
+
+	require.NoError(t, err)
+
+	bet := &pkg.Bet{
+		BetterID:      owner.ID,
+		CompetitionID: competition.ID,
+		CompetitorID:  competitor.ID,
+		Score:         null.IntFrom(5),
+	}
+
+	_, err = s.AddBet(ownerCtx, bet)
+	assert.Equal(t, pkg.ErrBadRequest, errors.Cause(err))
+
+	scheduled, err := s.GetScheduledLocks(ownerCtx)
+
+	require.NoError(t, err)
+	require.Len(t, scheduled, 1)
+	assert.Equal(t, competition.ID, scheduled[0].ID)
+
+	// Not locked before the lock time has passed.
+	locked, err := s.LockScheduledCompetition(ownerCtx, competition.ID)
+
+	require.NoError(t, err)
+	assert.False(t, locked)
+
+	require.NoError(t, s.DB.Gorm.Model(competition).Updates(map[string]interface{}{
+		"opens_at": time.Now().Add(-2 * time.Hour),
+		"lock_at":  time.Now().Add(-time.Hour),
+	}).Error)
+
+	_, err = s.AddBet(ownerCtx, bet)
+	assert.Equal(t, pkg.ErrBadRequest, errors.Cause(err))
+
+	locked, err = s.LockScheduledCompetition(ownerCtx, competition.ID)
+
+	require.NoError(t, err)
+	assert.True(t, locked)
+
+	// Only locked once.
+	locked, err = s.LockScheduledCompetition(ownerCtx, competition.ID)
+
+	require.NoError(t, err)
+	assert.False(t, locked)
+
+	scheduled, err = s.GetScheduledLocks(ownerCtx)
+
+	require.NoError(t, err)
+	assert.Empty(t, scheduled)
+}
+
+func TestService_LockedBets(t *testing.T) {
+	s := setupService(t)
+
+	owner := s.anyBetter()
+	ownerCtx := s.anyBetterContext()
+
+	competition, err := s.AddCompetition(ownerCtx, &pkg.Competition{
+		CreatedByID: owner.ID,
+		Name:        "Unittest competition",
+	})
+
+	require.NoError(t, err)
+
+	competitor, err := s.AddCompetitor(ownerCtx, &pkg.Competitor{
+		CreatedByID: owner.ID,
+		Name:        "Unittest competitor",
+	}, &competition.ID)
+
+	require.NoError(t, err)
+
+	tokens, err := s.AddBetter(context.Background(), &pkg.Better{
+		Name:  "Unittest better",
+		Email: null.StringFrom("better@test.se"),
+	})
+
+	require.NoError(t, err)
+
+	better, err := s.BetterFromJWT(context.Background(), tokens.JWT)
+
+	require.NoError(t, err)
+
+	betterCtx := pkg.ContextWithBetter(context.Background(), better)
+
+	_, err = s.JoinCompetition(betterCtx, competition.Code.String, better.ID)
+	require.NoError(t, err)
+
+	bet, err := s.AddBet(betterCtx, &pkg.Bet{
+		BetterID:      better.ID,
+		CompetitionID: competition.ID,
+		CompetitorID:  competitor.ID,
+		Score:         null.IntFrom(5),
+	})
+
+	require.NoError(t, err)
+	require.NoError(t, s.LockCompetition(ownerCtx, competition.ID))
+
+	correction := &pkg.Bet{
+		BetterID:      better.ID,
+		CompetitionID: competition.ID,
+		CompetitorID:  competitor.ID,
+		Score:         null.IntFrom(7),
+	}
+
+	_, err = s.AddBet(betterCtx, correction)
+	assert.Equal(t, pkg.ErrBadRequest, errors.Cause(err))
+
+	err = s.DeleteBet(betterCtx, bet.ID)
+	assert.Equal(t, pkg.ErrBadRequest, errors.Cause(err))
+
+	// Only the owner may override the lock.
+	_, err = s.AddBet(pkg.ContextWithLockOverride(betterCtx), correction)
+	assert.Equal(t, pkg.ErrForbidden, errors.Cause(err))
+
+	corrected, err := s.AddBet(pkg.ContextWithLockOverride(ownerCtx), correction)
+
+	require.NoError(t, err)
+	assert.Equal(t, bet.ID, corrected.ID)
+	assert.Equal(t, int64(7), corrected.Score.Int64)
+
+	require.NoError(t, s.DeleteBet(pkg.ContextWithLockOverride(ownerCtx), bet.ID))
+
+	_, err = s.GetCompetitionAudit(betterCtx, competition.ID)
+	assert.Equal(t, pkg.ErrForbidden, errors.Cause(err))
+
+	entries, err := s.GetCompetitionAudit(ownerCtx, competition.ID)
+
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+
+	assert.Equal(t, pkg.AuditLocked, entries[0].Action)
+	assert.Equal(t, pkg.AuditBetOverride, entries[1].Action)
+	assert.Equal(t, pkg.AuditBetDeleteOverride, entries[2].Action)
+
+	for _, entry := range entries[1:] {
+		assert.Equal(t, int64(owner.ID), entry.BetterID.Int64)
+		assert.Equal(t, int64(bet.ID), entry.BetID.Int64)
+	}
+}
+
+func TestService_UnlockCompetition(t *testing.T) {
+	s := setupService(t)
+
+	owner := s.anyBetter()
+	ownerCtx := s.anyBetterContext()
+
+	competition, err := s.AddCompetition(ownerCtx, &pkg.Competition{
+		CreatedByID: owner.ID,
+		Name:        "Unittest competition",
+	})
+
+	require.NoError(t, err)
+
+	competitor, err := s.AddCompetitor(ownerCtx, &pkg.Competitor{
+		CreatedByID: owner.ID,
+		Name:        "Unittest competitor",
+	}, &competition.ID)
+
+	require.NoError(t, err)
+
+	err = s.UnlockCompetition(ownerCtx, competition.ID)
+	assert.Equal(t, pkg.ErrBadRequest, errors.Cause(err))
+
+	require.NoError(t, s.LockCompetition(ownerCtx, competition.ID))
+
+	_, err = s.SetCompetitionResult(ownerCtx, competition.ID, []*pkg.Result{
+		{CompetitorID: competitor.ID, Placing: 1},
+	})
+
+	require.NoError(t, err)
+
+	err = s.UnlockCompetition(context.Background(), competition.ID)
+	assert.Equal(t, pkg.ErrForbidden, errors.Cause(err))
+
+	// The result must be cleared before unlocking.
+	err = s.UnlockCompetition(ownerCtx, competition.ID)
+	assert.Equal(t, pkg.ErrBadRequest, errors.Cause(err))
+
+	require.NoError(t, s.ClearCompetitionResult(ownerCtx, competition.ID))
+	require.NoError(t, s.UnlockCompetition(ownerCtx, competition.ID))
+
+	_, err = s.AddBet(ownerCtx, &pkg.Bet{
+		BetterID:      owner.ID,
+		CompetitionID: competition.ID,
+		CompetitorID:  competitor.ID,
+		Score:         null.IntFrom(5),
+	})
+
+	require.NoError(t, err)
+
+	entries, err := s.GetCompetitionAudit(ownerCtx, competition.ID)
+
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+
+	assert.Equal(t, pkg.AuditLocked, entries[0].Action)
+	assert.Equal(t, pkg.AuditResultCleared, entries[1].Action)
+	assert.Equal(t, pkg.AuditUnlocked, entries[2].Action)
+}
+
+func TestService_Update(t *testing.T) {
+	s := setupService(t)
+
+	owner := s.anyBetter()
+	ownerCtx := s.anyBetterContext()
+
+	competition, err := s.AddCompetition(ownerCtx, &pkg.Competition{
+		CreatedByID: owner.ID,
+		Name:        "Unittest competition",
+		Description: null.StringFrom("Some description"),
+		MaxScore:    10,
+	})
+
+	require.NoError(t, err)
+
+	competitor, err := s.AddCompetitor(ownerCtx, &pkg.Competitor{
+		CreatedByID: owner.ID,
+		Name:        "Unittest competitr",
+	}, &competition.ID)
+
+	require.NoError(t, err)
+
+	_, err = s.AddBet(ownerCtx, &pkg.Bet{
+		BetterID:      owner.ID,
+		CompetitionID: competition.ID,
+		CompetitorID:  competitor.ID,
+		Score:         null.IntFrom(8),
+	})
+
+	require.NoError(t, err)
+
+	updated, err := s.UpdateCompetition(ownerCtx, competition.ID, pkg.Patch{
+		"name":        json.RawMessage(`"Updated competition"`),
+		"description": json.RawMessage(`null`),
+		"max_score":   json.RawMessage(`12`),
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, "Updated competition", updated.Name)
+	assert.False(t, updated.Description.Valid)
+	assert.Equal(t, 12, updated.MaxScore)
+	assert.Equal(t, pkg.ScoringDefault, updated.ScoringRule)
+
+	// The existing bet has a score of 8.
+	_, err = s.UpdateCompetition(ownerCtx, competition.ID, pkg.Patch{
+		"max_score": json.RawMessage(`5`),
+	})
+
+	assert.Equal(t, pkg.ErrBadRequest, errors.Cause(err))
+
+	_, err = s.UpdateCompetition(ownerCtx, competition.ID, pkg.Patch{
+		"name": json.RawMessage(`""`),
+	})
+
+	require.Error(t, err)
+
+	_, err = s.UpdateCompetition(ownerCtx, competition.ID, pkg.Patch{
+		"locked": json.RawMessage(`true`),
+	})
+
+	assert.Equal(t, pkg.ErrBadRequest, errors.Cause(err))
+
+	_, err = s.UpdateCompetition(context.Background(), competition.ID, pkg.Patch{
+		"name": json.RawMessage(`"Not allowed"`),
+	})
+
+	assert.Equal(t, pkg.ErrForbidden, errors.Cause(err))
+
+	updatedCompetitor, err := s.UpdateCompetitor(ownerCtx, competitor.ID, pkg.Patch{
+		"name": json.RawMessage(`"Unittest competitor"`),
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, "Unittest competitor", updatedCompetitor.Name)
+
+	updatedBetter, err := s.UpdateBetter(ownerCtx, owner.ID, pkg.Patch{
+		"name": json.RawMessage(`"Updated better"`),
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, "Updated better", updatedBetter.Name)
+	assert.Equal(t, owner.Email, updatedBetter.Email)
+
+	_, err = s.UpdateBetter(ownerCtx, owner.ID, pkg.Patch{
+		"email": json.RawMessage(`"other@test.se"`),
+	})
+
+	assert.Equal(t, pkg.ErrBadRequest, errors.Cause(err))
+}
+
+func TestService_LinkCompetitors(t *testing.T) {
+	s := setupService(t)
+
+	owner := s.anyBetter()
+	ownerCtx := s.anyBetterContext()
+
+	competition, err := s.AddCompetition(ownerCtx, &pkg.Competition{
+		CreatedByID: owner.ID,
+		Name:        "Unittest competition",
+	})
+
+	require.NoError(t, err)
+
+	var competitorIDs []int
+
+	for i := range make([]int, 3) {
+		c, err := s.AddCompetitor(ownerCtx, &pkg.Competitor{
+			CreatedByID: owner.ID,
+			Name:        fmt.Sprintf("Unittest competitor %d", i+1),
+		}, nil)
+
+		require.NoError(t, err)
+
+		competitorIDs = append(competitorIDs, c.ID)
+	}
+
+	_, err = s.LinkCompetitors(context.Background(), competition.ID, competitorIDs)
+	assert.Equal(t, pkg.ErrForbidden, errors.Cause(err))
+
+	_, err = s.LinkCompetitors(ownerCtx, competition.ID, []int{competitorIDs[0], 1000})
+	assert.Equal(t, pkg.ErrNotFound, errors.Cause(err))
+
+	linked, err := s.LinkCompetitors(ownerCtx, competition.ID, competitorIDs)
+
+	require.NoError(t, err)
+	assert.Len(t, linked, 3)
+
+	// Only competitors not already linked are added.
+	linked, err = s.LinkCompetitors(ownerCtx, competition.ID, competitorIDs[:1])
+
+	require.NoError(t, err)
+	assert.Empty(t, linked)
+
+	competitors, err := s.GetCompetitorsForCompetition(ownerCtx, competition.ID)
+
+	require.NoError(t, err)
+	assert.Len(t, competitors, 3)
+
+	_, err = s.AddBet(ownerCtx, &pkg.Bet{
+		BetterID:      owner.ID,
+		CompetitionID: competition.ID,
+		CompetitorID:  competitorIDs[0],
+		Score:         null.IntFrom(5),
+	})
+
+	require.NoError(t, err)
+
+	require.NoError(t, s.UnlinkCompetitor(ownerCtx, competition.ID, competitorIDs[1], false))
+
+	// Bets are placed on the competitor so it must be forced.
+	err = s.UnlinkCompetitor(ownerCtx, competition.ID, competitorIDs[0], false)
+	assert.Equal(t, pkg.ErrBadRequest, errors.Cause(err))
+
+	require.NoError(t, s.UnlinkCompetitor(ownerCtx, competition.ID, competitorIDs[0], true))
+
+	err = s.UnlinkCompetitor(ownerCtx, competition.ID, competitorIDs[0], true)
+	assert.Equal(t, pkg.ErrNotFound, errors.Cause(err))
+
+	competitors, err = s.GetCompetitorsForCompetition(ownerCtx, competition.ID)
+
+	require.NoError(t, err)
+	require.Len(t, competitors, 1)
+	assert.Equal(t, competitorIDs[2], competitors[0].ID)
+
+	bets, err := s.GetBetsForCompetition(ownerCtx, competition.ID)
+
+	require.NoError(t, err)
+	assert.Empty(t, bets)
+
+	// The competitor still exists.
+	_, err = s.GetCompetitor(ownerCtx, competitorIDs[0])
+	require.NoError(t, err)
+
+	linked, err = s.LinkCompetitors(ownerCtx, competition.ID, competitorIDs)
+
+	require.NoError(t, err)
+	require.Len(t, linked, 2)
+	assert.NotEqual(t, competitorIDs[2], linked[0].ID)
+	assert.NotEqual(t, competitorIDs[2], linked[1].ID)
+
+	require.NoError(t, s.LockCompetition(ownerCtx, competition.ID))
+
+	_, err = s.LinkCompetitors(ownerCtx, competition.ID, competitorIDs[:1])
+	assert.Equal(t, pkg.ErrBadRequest, errors.Cause(err), "competitors may not be linked to a locked competition")
+
+	_, err = s.AddCompetitor(ownerCtx, &pkg.Competitor{
+		CreatedByID: owner.ID,
+		Name:        "Unittest competitor 4",
+	}, &competition.ID)
+
+	assert.Equal(t, pkg.ErrBadRequest, errors.Cause(err), "competitors may not be added to a locked competition")
+
+	_, err = s.AddBet(pkg.ContextWithLockOverride(ownerCtx), &pkg.Bet{
+		BetterID:      owner.ID,
+		CompetitionID: competition.ID,
+		CompetitorID:  competitorIDs[2],
+		Score:         null.IntFrom(5),
+	})
+
+	require.NoError(t, err)
+
+	err = s.UnlinkCompetitor(ownerCtx, competition.ID, competitorIDs[2], true)
+	assert.Equal(t, pkg.ErrBadRequest, errors.Cause(err), "competitors may not be unlinked from a locked competition")
+
+	bets, err = s.GetBetsForCompetition(ownerCtx, competition.ID)
+
+	require.NoError(t, err)
+	assert.Len(t, bets, 1, "bets are kept when unlinking fails")
+
+	require.NoError(t, s.UnlinkCompetitor(pkg.ContextWithLockOverride(ownerCtx), competition.ID, competitorIDs[2], true))
+
+	entries, err := s.GetCompetitionAudit(ownerCtx, competition.ID)
+
+	require.NoError(t, err)
+	require.NotEmpty(t, entries)
+	assert.Equal(t, pkg.AuditCompetitorRemoved, entries[len(entries)-1].Action)
+}
+
+func TestService_BetVisibility(t *testing.T) {
+	s := setupService(t)
+
+	owner := s.anyBetter()
+	ownerCtx := s.anyBetterContext()
+
+	competition, err := s.AddCompetition(ownerCtx, &pkg.Competition{
+		CreatedByID:   owner.ID,
+		Name:          "Unittest competition",
+		BetVisibility: pkg.BetVisibilityAfterLock,
+	})
+
+	require.NoError(t, err)
+
+	competitor, err := s.AddCompetitor(ownerCtx, &pkg.Competitor{
+		CreatedByID: owner.ID,
+		Name:        "Unittest competitor",
+	}, &competition.ID)
+
+	require.NoError(t, err)
+
+	tokens, err := s.AddBetter(context.Background(), &pkg.Better{
+		Name:  "Unittest better",
+		Email: null.StringFrom("better@test.se"),
+	})
+
+	require.NoError(t, err)
+
+	better, err := s.BetterFromJWT(context.Background(), tokens.JWT)
+
+	require.NoError(t, err)
+
+	betterCtx := pkg.ContextWithBetter(context.Background(), better)
+
+	_, err = s.JoinCompetition(betterCtx, competition.Code.String, better.ID)
+	require.NoError(t, err)
+
+	bet, err := s.AddBet(betterCtx, &pkg.Bet{
+		BetterID:      better.ID,
+		CompetitionID: competition.ID,
+		CompetitorID:  competitor.ID,
+		Score:         null.IntFrom(5),
+	})
+
+	require.NoError(t, err)
+
+	// The better always sees their own bet.
+	b, err := s.GetBet(betterCtx, bet.ID)
+
+	require.NoError(t, err)
+	assert.False(t, b.Hidden)
+	assert.Equal(t, int64(5), b.Score.Int64)
+
+	// Other betters only see that a bet has been placed until locked.
+	b, err = s.GetBet(ownerCtx, bet.ID)
+
+	require.NoError(t, err)
+	assert.True(t, b.Hidden)
+	assert.False(t, b.Score.Valid)
+	assert.Equal(t, competitor.ID, b.CompetitorID)
+
+	c, err := s.GetCompetition(ownerCtx, competition.ID)
+
+	require.NoError(t, err)
+	require.Len(t, c.Bets, 1)
+	assert.True(t, c.Bets[0].Hidden)
+
+	_, err = s.GetCompetitionMetrics(ownerCtx, competition.ID)
+	assert.Equal(t, pkg.ErrForbidden, errors.Cause(err))
+
+	require.NoError(t, s.LockCompetition(ownerCtx, competition.ID))
+
+	c, err = s.GetCompetition(ownerCtx, competition.ID)
+
+	require.NoError(t, err)
+	require.Len(t, c.Bets, 1)
+	assert.False(t, c.Bets[0].Hidden)
+	assert.Equal(t, int64(5), c.Bets[0].Score.Int64)
+}
+
+func TestKeySet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	require.NoError(t, err)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+
+	require.NoError(t, err)
+
+	writePEM := func(name, pemType string, der []byte) string {
+		path := filepath.Join(dir, name)
+
+		require.NoError(t, ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: pemType, Bytes: der}), 0600))
+
+		return path
+	}
+
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+
+	require.NoError(t, err)
+
+	rsaPublicDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+
+	require.NoError(t, err)
+
+	rsaPrivate, err := LoadPEMKey("rsa", writePEM("rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)))
+
+	require.NoError(t, err)
+
+	rsaPublic, err := LoadPEMKey("rsa", writePEM("rsa.pub", "PUBLIC KEY", rsaPublicDER))
+
+	require.NoError(t, err)
+	require.Nil(t, rsaPublic.SignKey)
+
+	edPrivate, err := LoadPEMKey("ed", writePEM("ed.pem", "PRIVATE KEY", edDER))
+
+	require.NoError(t, err)
+
+	var (
+		ctx     = context.Background()
+		better  = &pkg.Better{ID: 1, Name: "Unittest better", Email: null.StringFrom("user@test.se")}
+		oldKey  = NewHMACKey("old", []byte("0ld"))
+		newKey  = NewHMACKey("new", []byte("n3w"))
+		unknown = NewHMACKey("unknown", []byte("0ld"))
+	)
+
+	cases := []struct {
+		description string
+		signWith    *KeySet
+		verifyWith  *KeySet
+		errContains string
+	}{
+		{
+			description: "hmac",
+			signWith:    NewKeySet(oldKey),
+			verifyWith:  NewKeySet(oldKey),
+		},
+		{
+			description: "old key still valid while rotating",
+			signWith:    NewKeySet(oldKey),
+			verifyWith:  NewKeySet(newKey, oldKey),
+		},
+		{
+			description: "old key removed",
+			signWith:    NewKeySet(oldKey),
+			verifyWith:  NewKeySet(newKey),
+			errContains: "unknown key id: old",
+		},
+		{
+			description: "unknown kid with same secret",
+			signWith:    NewKeySet(unknown),
+			verifyWith:  NewKeySet(oldKey),
+			errContains: "unknown key id: unknown",
+		},
+		{
+			description: "rs256 verified with public key",
+			signWith:    NewKeySet(rsaPrivate),
+			verifyWith:  NewKeySet(newKey, rsaPublic),
+		},
+		{
+			description: "eddsa",
+			signWith:    NewKeySet(edPrivate),
+			verifyWith:  NewKeySet(edPrivate),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			token, err := (&Service{Keys: tc.signWith}).JWTForBetter(ctx, better)
+
+			require.NoError(t, err)
+
+			claims, err := tc.verifyWith.parse(token)
+
+			if tc.errContains != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.errContains)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, better.ID, claims.ID)
+		})
+	}
+}
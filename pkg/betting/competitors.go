@@ -0,0 +1,188 @@
+package betting
+
+import (
+	"context"
+
+	"github.com/guregu/null"
+	"github.com/pkg/errors"
+
+	"github.com/bombsimon/team-betting/pkg"
+)
+
+// LinkCompetitors will add existing competitors to a competition and return
+// the competitors that were added. Competitors already competing in the
+// competition are ignored. Competitors may not be linked once the competition
+// is locked or has a result. Only the owner and co-hosts of the competition
+// may link competitors.
+func (s *Service) LinkCompetitors(ctx context.Context, competitionID int, competitorIDs []int) ([]*pkg.Competitor, error) {
+	if err := requireScope(ctx, pkg.ScopeAdmin, "link competitors"); err != nil {
+		return nil, err
+	}
+
+	if len(competitorIDs) == 0 {
+		return nil, errors.Wrap(pkg.ErrBadRequest, "no competitors to link")
+	}
+
+	competition, err := s.getCompetition(competitionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireRole(ctx, competition, "link competitors", pkg.RoleOwner, pkg.RoleCoHost); err != nil {
+		return nil, err
+	}
+
+	if competition.Locked {
+		return nil, errors.Wrap(pkg.ErrBadRequest, "competition is locked, unlock it before linking competitors")
+	}
+
+	var results int
+
+	if err := s.DB.Gorm.Model(&pkg.Result{}).Where("competition_id = ?", competitionID).Count(&results).Error; err != nil {
+		return nil, errors.Wrap(err, "could not get result for competition")
+	}
+
+	if results > 0 {
+		return nil, errors.Wrap(pkg.ErrBadRequest, "competition has a result, clear the result before linking competitors")
+	}
+
+	unique := map[int]struct{}{}
+	for _, id := range competitorIDs {
+		unique[id] = struct{}{}
+	}
+
+	competitors, err := s.GetCompetitors(ctx, competitorIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(competitors) != len(unique) {
+		return nil, errors.Wrap(pkg.ErrNotFound, "one or more competitors not found")
+	}
+
+	linked := map[int]struct{}{}
+	for _, c := range competition.Competitors {
+		linked[c.ID] = struct{}{}
+	}
+
+	added := []*pkg.Competitor{}
+
+	for _, c := range competitors {
+		if _, ok := linked[c.ID]; !ok {
+			added = append(added, c)
+		}
+	}
+
+	if len(added) == 0 {
+		return added, nil
+	}
+
+	if err := s.DB.Gorm.Model(competition).Association("Competitors").Append(added).Error; err != nil {
+		return nil, errors.Wrap(err, "could not link competitors to competition")
+	}
+
+	return added, nil
+}
+
+// UnlinkCompetitor will remove a competitor from a competition. If bets are
+// placed on the competitor in the competition the competitor is only removed
+// when forced, which also deletes the bets. A competitor with a result can't
+// be removed until the result is cleared and competitors can't be removed from
+// a locked competition unless the owner overrides the lock. Only the owner and
+// co-hosts of the competition may unlink competitors.
+func (s *Service) UnlinkCompetitor(ctx context.Context, competitionID, competitorID int, force bool) error {
+	if err := requireScope(ctx, pkg.ScopeAdmin, "unlink competitors"); err != nil {
+		return err
+	}
+
+	competition, err := s.getCompetition(competitionID)
+	if err != nil {
+		return err
+	}
+
+	if err := requireRole(ctx, competition, "unlink competitors", pkg.RoleOwner, pkg.RoleCoHost); err != nil {
+		return err
+	}
+
+	override, err := requireUnlocked(ctx, competition, "unlink competitors")
+	if err != nil {
+		return err
+	}
+
+	var competitor *pkg.Competitor
+
+	for _, c := range competition.Competitors {
+		if c.ID == competitorID {
+			competitor = c
+		}
+	}
+
+	if competitor == nil {
+		return errors.Wrap(pkg.ErrNotFound, "competitor does not compete in competition")
+	}
+
+	var results int
+
+	err = s.DB.Gorm.Model(&pkg.Result{}).
+		Where("competition_id = ? AND competitor_id = ?", competitionID, competitorID).
+		Count(&results).
+		Error
+
+	if err != nil {
+		return errors.Wrap(err, "could not get result for competitor")
+	}
+
+	if results > 0 {
+		return errors.Wrap(pkg.ErrBadRequest, "competitor has a result, clear the result before unlinking")
+	}
+
+	var bets int
+
+	for _, b := range competition.Bets {
+		if b.CompetitorID == competitorID {
+			bets++
+		}
+	}
+
+	if bets > 0 && !force {
+		return errors.Wrapf(pkg.ErrBadRequest, "%d bets are placed on the competitor, force to unlink and delete them", bets)
+	}
+
+	tx := s.DB.Gorm.Begin()
+
+	if err := tx.Model(competition).Association("Competitors").Delete(competitor).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "could not unlink competitor from competition")
+	}
+
+	if bets > 0 {
+		err := tx.
+			Where("competition_id = ? AND competitor_id = ?", competitionID, competitorID).
+			Delete(&pkg.Bet{}).
+			Error
+
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "could not delete bets for competitor")
+		}
+	}
+
+	if bets > 0 || override {
+		details := map[string]interface{}{
+			"competitor_id": competitorID,
+			"deleted_bets":  bets,
+			"override":      override,
+		}
+
+		if err := audit(ctx, tx, competitionID, pkg.AuditCompetitorRemoved, null.Int{}, details); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.Wrap(err, "could not unlink competitor from competition")
+	}
+
+	return nil
+}
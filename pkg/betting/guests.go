@@ -0,0 +1,114 @@
+package betting
+
+import (
+	"context"
+	"strings"
+
+	"github.com/guregu/null"
+	"github.com/pkg/errors"
+
+	"github.com/bombsimon/team-betting/pkg"
+	"github.com/bombsimon/team-betting/pkg/database"
+)
+
+// DefaultMaxGuests is the maximum number of guests that may join a
+// competition if not configured on the service.
+const DefaultMaxGuests = 100
+
+// JoinCompetitionAsGuest will create a guest better with only a name, add it
+// as a participant in the competition with the passed code and sign it in. The
+// guest may only take part in this competition until upgraded. Joining as a
+// guest is unauthenticated so the number of guests in each competition is
+// limited.
+func (s *Service) JoinCompetitionAsGuest(ctx context.Context, code, name string) (*pkg.Tokens, error) {
+	competition, err := s.GetCompetitionByCode(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	var guests int
+
+	err = s.DB.Gorm.Model(&pkg.Better{}).
+		Where("guest_competition_id = ?", competition.ID).
+		Count(&guests).
+		Error
+
+	if err != nil {
+		return nil, errors.Wrap(err, "could not count guests")
+	}
+
+	if guests >= s.maxGuests() {
+		return nil, errors.Wrap(pkg.ErrBadRequest, "competition is full, no more guests may join")
+	}
+
+	guest := pkg.Better{
+		Name:               strings.TrimSpace(name),
+		GuestCompetitionID: null.IntFrom(int64(competition.ID)),
+	}
+
+	if err := guest.Validate(); err != nil {
+		return nil, errors.Wrap(err, "bad request")
+	}
+
+	if err := s.DB.Gorm.Save(&guest).Error; err != nil {
+		return nil, errors.Wrap(err, "could not create guest")
+	}
+
+	if _, err := s.addMember(competition.ID, guest.ID, pkg.RoleParticipant); err != nil {
+		return nil, err
+	}
+
+	return s.signIn(ctx, &guest)
+}
+
+func (s *Service) maxGuests() int {
+	if s.MaxGuests == 0 {
+		return DefaultMaxGuests
+	}
+
+	return s.MaxGuests
+}
+
+// UpgradeGuest will turn a guest into a regular better by adding an email. All
+// bets and memberships are kept. New tokens are returned since the tokens
+// issued to the guest are limited to the competition it joined.
+func (s *Service) UpgradeGuest(ctx context.Context, betterID int, email string) (*pkg.Tokens, error) {
+	if err := requireSession(ctx, "upgrade guests"); err != nil {
+		return nil, err
+	}
+
+	if err := requireOwner(ctx, betterID, "upgrade the guest"); err != nil {
+		return nil, err
+	}
+
+	better, err := s.GetBetter(ctx, betterID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !better.GuestCompetitionID.Valid {
+		return nil, errors.Wrap(pkg.ErrBadRequest, "better is not a guest")
+	}
+
+	better.Email = null.StringFrom(normalizeEmail(email))
+	better.GuestCompetitionID = null.Int{}
+
+	if err := better.Validate(); err != nil {
+		return nil, errors.Wrap(err, "bad request")
+	}
+
+	err = s.DB.Gorm.Model(better).Updates(map[string]interface{}{
+		"email":                better.Email,
+		"guest_competition_id": nil,
+	}).Error
+
+	if err != nil {
+		if database.ErrType(err) == database.ErrDuplicateKey {
+			return nil, errors.Wrap(pkg.ErrBadRequest, "a user with that email already exist")
+		}
+
+		return nil, errors.Wrap(err, "could not upgrade guest")
+	}
+
+	return s.signIn(ctx, better)
+}
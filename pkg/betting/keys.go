@@ -0,0 +1,259 @@
+package betting
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+
+	"github.com/bombsimon/team-betting/pkg"
+)
+
+// SigningMethodEdDSA is the EdDSA signing method for JWTs using Ed25519 keys.
+var SigningMethodEdDSA = &signingMethodEdDSA{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+// SigningKey is a key used to sign and verify JWTs. The key ID is set as the
+// `kid` header in the JWT. A key loaded from a public key can only be used to
+// verify tokens.
+type SigningKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	SignKey   interface{}
+	VerifyKey interface{}
+}
+
+// KeySet holds all keys that are valid to verify JWTs and the key used to sign
+// new JWTs. Multiple keys may be valid at once when rotating keys.
+type KeySet struct {
+	SigningKeyID string
+	Keys         map[string]*SigningKey
+}
+
+// NewKeySet creates a new key set where the first key is used to sign new
+// tokens and all keys are valid to verify tokens.
+func NewKeySet(signingKey *SigningKey, keys ...*SigningKey) *KeySet {
+	ks := &KeySet{
+		SigningKeyID: signingKey.ID,
+		Keys: map[string]*SigningKey{
+			signingKey.ID: signingKey,
+		},
+	}
+
+	for _, k := range keys {
+		ks.Keys[k.ID] = k
+	}
+
+	return ks
+}
+
+// KeySetFromEnv creates a key set from the environment.
+//
+//  JWT_KEYS          comma separated HMAC secrets as `kid:secret`
+//  JWT_KEY_FILES     comma separated PEM files as `kid:/path/to/key.pem`
+//  JWT_SIGNING_KEY   the kid for the key used to sign new tokens
+//
+// PEM files may hold an RSA (RS256) or an Ed25519 (EdDSA) key. A private key
+// may both sign and verify tokens while a public key may only verify tokens.
+// The signing key may be omitted if only one key is configured.
+func KeySetFromEnv() (*KeySet, error) {
+	ks := &KeySet{
+		SigningKeyID: os.Getenv("JWT_SIGNING_KEY"),
+		Keys:         map[string]*SigningKey{},
+	}
+
+	for _, kv := range splitKeyValues(os.Getenv("JWT_KEYS")) {
+		ks.Keys[kv[0]] = NewHMACKey(kv[0], []byte(kv[1]))
+	}
+
+	for _, kv := range splitKeyValues(os.Getenv("JWT_KEY_FILES")) {
+		key, err := LoadPEMKey(kv[0], kv[1])
+		if err != nil {
+			return nil, err
+		}
+
+		ks.Keys[kv[0]] = key
+	}
+
+	if len(ks.Keys) == 0 {
+		return nil, errors.New("no JWT keys configured, set JWT_KEYS or JWT_KEY_FILES")
+	}
+
+	if ks.SigningKeyID == "" && len(ks.Keys) == 1 {
+		for id := range ks.Keys {
+			ks.SigningKeyID = id
+		}
+	}
+
+	signingKey, ok := ks.Keys[ks.SigningKeyID]
+	if !ok {
+		return nil, errors.Errorf("signing key '%s' not found, set JWT_SIGNING_KEY", ks.SigningKeyID)
+	}
+
+	if signingKey.SignKey == nil {
+		return nil, errors.Errorf("signing key '%s' is a public key", ks.SigningKeyID)
+	}
+
+	return ks, nil
+}
+
+// NewHMACKey creates a new HS256 key from a shared secret.
+func NewHMACKey(id string, secret []byte) *SigningKey {
+	return &SigningKey{
+		ID:        id,
+		Method:    jwt.SigningMethodHS256,
+		SignKey:   secret,
+		VerifyKey: secret,
+	}
+}
+
+// LoadPEMKey loads an RSA or Ed25519 private or public key from a PEM file.
+func LoadPEMKey(id, path string) (*SigningKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read key file %s", path)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.Errorf("no PEM data found in %s", path)
+	}
+
+	var key interface{}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, errors.Errorf("unsupported PEM type %s in %s", block.Type, path)
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse key in %s", path)
+	}
+
+	sk := &SigningKey{ID: id}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sk.Method, sk.SignKey, sk.VerifyKey = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		sk.Method, sk.VerifyKey = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		sk.Method, sk.SignKey, sk.VerifyKey = SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		sk.Method, sk.VerifyKey = SigningMethodEdDSA, k
+	default:
+		return nil, errors.Errorf("unsupported key type %T in %s", key, path)
+	}
+
+	return sk, nil
+}
+
+// signingKey returns the key used to sign new tokens.
+func (ks *KeySet) signingKey() (*SigningKey, error) {
+	key, ok := ks.Keys[ks.SigningKeyID]
+	if !ok || key.SignKey == nil {
+		return nil, errors.New("no signing key configured")
+	}
+
+	return key, nil
+}
+
+// keyFunc returns the key to verify a token with based on the `kid` header.
+// Tokens without a kid are verified with the signing key.
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = ks.SigningKeyID
+	}
+
+	key, ok := ks.Keys[kid]
+	if !ok {
+		return nil, errors.Errorf("unknown key id: %s", kid)
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, errors.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key.VerifyKey, nil
+}
+
+// parse will parse and verify a JWT and return the claims.
+func (ks *KeySet) parse(tokenString string) (*pkg.Claims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &pkg.Claims{}, ks.keyFunc)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse token")
+	}
+
+	claims, ok := token.Claims.(*pkg.Claims)
+	if !(ok && token.Valid) {
+		return nil, errors.New("could not get token claims")
+	}
+
+	return claims, nil
+}
+
+func splitKeyValues(s string) [][2]string {
+	var kvs [][2]string
+
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), ":", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			continue
+		}
+
+		kvs = append(kvs, [2]string{kv[0], kv[1]})
+	}
+
+	return kvs
+}
+
+type signingMethodEdDSA struct{}
+
+func (m *signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+func (m *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+
+	return nil
+}
+
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
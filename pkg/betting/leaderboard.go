@@ -0,0 +1,150 @@
+package betting
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/bombsimon/team-betting/pkg"
+)
+
+// GetCompetitionLeaderboard will score every better in a competition against
+// the stored result and return them ranked by their points.
+func (s *Service) GetCompetitionLeaderboard(ctx context.Context, id int) ([]*pkg.LeaderboardEntry, error) {
+	competition, err := s.getCompetition(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*pkg.Result
+
+	if err := s.DB.Gorm.Where("competition_id = ?", id).Find(&results).Error; err != nil {
+		return nil, errors.Wrap(err, "could not get result for competition")
+	}
+
+	if len(results) == 0 {
+		return nil, errors.Wrap(pkg.ErrNotFound, "no result set for competition")
+	}
+
+	rule, err := ScoringRuleFor(competition.ScoringRule)
+	if err != nil {
+		return nil, err
+	}
+
+	return leaderboard(competition.Bets, results, rule), nil
+}
+
+// leaderboard will score the bets against the result with the given scoring
+// rule and return one entry per better, sorted and ranked by the points.
+func leaderboard(bets []*pkg.Bet, results []*pkg.Result, rule pkg.ScoringRule) []*pkg.LeaderboardEntry {
+	actual := map[int]int{}
+	for _, r := range results {
+		actual[r.CompetitorID] = r.Placing
+	}
+
+	var (
+		entries      = []*pkg.LeaderboardEntry{}
+		betsByBetter = map[int][]*pkg.Bet{}
+		betters      = map[int]*pkg.Better{}
+	)
+
+	for _, bet := range bets {
+		if _, ok := betsByBetter[bet.BetterID]; !ok {
+			betters[bet.BetterID] = bet.Better
+		}
+
+		betsByBetter[bet.BetterID] = append(betsByBetter[bet.BetterID], bet)
+	}
+
+	// Iterate over the betters in a stable order so betters with the same
+	// points always end up in the same order.
+	betterIDs := make([]int, 0, len(betsByBetter))
+	for betterID := range betsByBetter {
+		betterIDs = append(betterIDs, betterID)
+	}
+
+	sort.Ints(betterIDs)
+
+	for _, betterID := range betterIDs {
+		entry := &pkg.LeaderboardEntry{
+			Better: betters[betterID],
+		}
+
+		predicted := predictedPlacings(betsByBetter[betterID])
+
+		for competitorID, placing := range predicted {
+			if actualPlacing, ok := actual[competitorID]; ok && placing == actualPlacing {
+				entry.ExactHits++
+			}
+		}
+
+		entry.Points = rule.Score(predicted, actual)
+
+		entries = append(entries, entry)
+	}
+
+	rankEntries(entries)
+
+	return entries
+}
+
+// predictedPlacings returns the placing a better predicted for each competitor.
+// An explicit placing on the bet is always used if set. Bets without a placing
+// but with a score will be placed after the explicitly placed competitors,
+// ordered by the score where the highest score gets the best placing.
+func predictedPlacings(bets []*pkg.Bet) map[int]int {
+	var (
+		placings = map[int]int{}
+		scored   = []*pkg.Bet{}
+	)
+
+	for _, bet := range bets {
+		if bet.Placing.Valid {
+			placings[bet.CompetitorID] = int(bet.Placing.Int64)
+			continue
+		}
+
+		if bet.Score.Valid {
+			scored = append(scored, bet)
+		}
+	}
+
+	sort.SliceStable(scored, func(i, j int) bool {
+		return scored[i].Score.Int64 > scored[j].Score.Int64
+	})
+
+	for i, bet := range scored {
+		placing := len(placings) + 1
+
+		// Competitors with the same score share the same placing.
+		if i > 0 && bet.Score.Int64 == scored[i-1].Score.Int64 {
+			placing = placings[scored[i-1].CompetitorID]
+		}
+
+		placings[bet.CompetitorID] = placing
+	}
+
+	return placings
+}
+
+// rankEntries will sort the entries by points and exact hits and set the rank
+// for each entry. Entries with equal points and exact hits share the same rank
+// and the next rank is skipped, i.e. 1, 2, 2, 4.
+func rankEntries(entries []*pkg.LeaderboardEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Points != entries[j].Points {
+			return entries[i].Points > entries[j].Points
+		}
+
+		return entries[i].ExactHits > entries[j].ExactHits
+	})
+
+	for i, e := range entries {
+		e.Rank = i + 1
+
+		if i > 0 && e.Points == entries[i-1].Points && e.ExactHits == entries[i-1].ExactHits {
+			e.Rank = entries[i-1].Rank
+		}
+	}
+}
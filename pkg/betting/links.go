@@ -0,0 +1,86 @@
+package betting
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/bombsimon/team-betting/pkg"
+)
+
+// DefaultLinkTTL is how long a sign in link is valid if not configured on the
+// service.
+const DefaultLinkTTL = 2 * time.Hour
+
+// signLink will encode the sign in data and sign it with the link secret. The
+// link is the URL safe base64 encoded JSON payload and the HMAC of the payload
+// separated by a dot.
+func (s *Service) signLink(data *pkg.SignInData) (string, error) {
+	if len(s.LinkSecret) == 0 {
+		return "", errors.New("no link secret configured")
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return "", errors.Wrap(err, "could not marshal link data")
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.linkMAC(encoded)), nil
+}
+
+// parseLink will verify the signature and expiry of a sign in link and return
+// the sign in data.
+func (s *Service) parseLink(link string) (*pkg.SignInData, error) {
+	if len(s.LinkSecret) == 0 {
+		return nil, errors.New("no link secret configured")
+	}
+
+	parts := strings.Split(link, ".")
+	if len(parts) != 2 {
+		return nil, errors.Wrap(pkg.ErrBadRequest, "invalid link")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, s.linkMAC(parts[0])) {
+		return nil, errors.Wrap(pkg.ErrBadRequest, "invalid link")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.Wrap(pkg.ErrBadRequest, "invalid link")
+	}
+
+	var data pkg.SignInData
+
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return nil, errors.Wrap(pkg.ErrBadRequest, "invalid link")
+	}
+
+	if data.ExpiresAt.Before(time.Now()) {
+		return nil, errors.Wrap(pkg.ErrBadRequest, "link has expired")
+	}
+
+	return &data, nil
+}
+
+func (s *Service) linkMAC(payload string) []byte {
+	mac := hmac.New(sha256.New, s.LinkSecret)
+	mac.Write([]byte(payload))
+
+	return mac.Sum(nil)
+}
+
+func (s *Service) linkTTL() time.Duration {
+	if s.LinkTTL == 0 {
+		return DefaultLinkTTL
+	}
+
+	return s.LinkTTL
+}
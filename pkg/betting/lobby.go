@@ -0,0 +1,85 @@
+package betting
+
+import (
+	"context"
+	"crypto/rand"
+	"math/big"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/bombsimon/team-betting/pkg"
+)
+
+const (
+	// codeAlphabet is the characters used for competition codes. Characters
+	// that are easy to mix up such as 0/O and 1/I are left out.
+	codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+	// codeLength is the length of a generated competition code.
+	codeLength = 6
+
+	// maxCodeAttempts is the number of times we try to generate a unique code
+	// before giving up.
+	maxCodeAttempts = 5
+)
+
+// GetCompetitionByCode will return a competition based on the competition
+// code.
+func (s *Service) GetCompetitionByCode(ctx context.Context, code string) (*pkg.Competition, error) {
+	var competition pkg.Competition
+
+	if s.DB.Gorm.Where("code = ?", normalizeCode(code)).First(&competition).RecordNotFound() {
+		return nil, errors.Wrap(pkg.ErrNotFound, "no competition found")
+	}
+
+	return s.GetCompetition(ctx, competition.ID)
+}
+
+// JoinCompetition will add the better as a participant of the competition
+// with the passed code. Joining a competition the better is already a member
+// of is not an error and will not change the role.
+func (s *Service) JoinCompetition(ctx context.Context, code string, betterID int) (*pkg.Competition, error) {
+	if err := requireScope(ctx, pkg.ScopeBet, "join competitions"); err != nil {
+		return nil, err
+	}
+
+	competition, err := s.GetCompetitionByCode(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireGuestAccess(ctx, competition.ID, "join other competitions"); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.addMember(competition.ID, betterID, pkg.RoleParticipant); err != nil {
+		return nil, err
+	}
+
+	return s.GetCompetition(ctx, competition.ID)
+}
+
+// generateCode will generate a random, human friendly code used to join a
+// competition.
+func generateCode() (string, error) {
+	var (
+		code = make([]byte, codeLength)
+		max  = big.NewInt(int64(len(codeAlphabet)))
+	)
+
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", errors.Wrap(err, "could not generate code")
+		}
+
+		code[i] = codeAlphabet[n.Int64()]
+	}
+
+	return string(code), nil
+}
+
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
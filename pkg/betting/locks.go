@@ -0,0 +1,122 @@
+package betting
+
+import (
+	"context"
+	"time"
+
+	"github.com/guregu/null"
+	"github.com/pkg/errors"
+
+	"github.com/bombsimon/team-betting/pkg"
+)
+
+// requireOpen ensures the competition is open for bets, i.e. not locked and
+// within the window when bets may be placed. The owner may override this to
+// correct bets by asking for it, the returned value tells if the lock was
+// overridden and the action must be audited.
+func requireOpen(ctx context.Context, competition *pkg.Competition, what string) (bool, error) {
+	if competition.OpensAt.Valid && time.Now().Before(competition.OpensAt.Time) {
+		return overrideLock(ctx, competition, "competition is not open for bets yet", what)
+	}
+
+	return requireUnlocked(ctx, competition, what)
+}
+
+// requireUnlocked ensures the competition isn't locked, either manually or by
+// passing the scheduled lock time. Unlike requireOpen it may be used before the
+// competition opens, e.g. to change the competitors. The owner may override
+// this the same way as for requireOpen.
+func requireUnlocked(ctx context.Context, competition *pkg.Competition, what string) (bool, error) {
+	switch {
+	case competition.Locked:
+		return overrideLock(ctx, competition, "competition is locked", what)
+	case competition.LockAt.Valid && !time.Now().Before(competition.LockAt.Time):
+		return overrideLock(ctx, competition, "competition is closed for bets", what)
+	default:
+		return false, nil
+	}
+}
+
+// overrideLock returns an error with the reason unless the owner asked to
+// override the lock.
+func overrideLock(ctx context.Context, competition *pkg.Competition, reason, what string) (bool, error) {
+	if !pkg.LockOverrideFromContext(ctx) {
+		return false, errors.Wrapf(pkg.ErrBadRequest, "%s, may not %s", reason, what)
+	}
+
+	if err := requireRole(ctx, competition, "override the lock", pkg.RoleOwner); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// UnlockCompetition will unlock a locked competition so bets may be placed
+// again. A competition with a result can't be unlocked until the result is
+// cleared. If the scheduled lock time has passed it's removed so the
+// competition isn't locked again right away. Only the owner of the
+// competition may unlock it.
+func (s *Service) UnlockCompetition(ctx context.Context, id int) error {
+	if err := requireScope(ctx, pkg.ScopeAdmin, "unlock competitions"); err != nil {
+		return err
+	}
+
+	c, err := s.getCompetition(id)
+	if err != nil {
+		return err
+	}
+
+	if err := requireRole(ctx, c, "unlock the competition", pkg.RoleOwner); err != nil {
+		return err
+	}
+
+	if !c.Locked {
+		return errors.Wrap(pkg.ErrBadRequest, "competition not locked")
+	}
+
+	var results int
+
+	if err := s.DB.Gorm.Model(&pkg.Result{}).Where("competition_id = ?", id).Count(&results).Error; err != nil {
+		return errors.Wrap(err, "could not get result for competition")
+	}
+
+	if results > 0 {
+		return errors.Wrap(pkg.ErrBadRequest, "competition has a result, clear the result before unlocking")
+	}
+
+	updates := map[string]interface{}{"locked": false}
+
+	if c.LockAt.Valid && !time.Now().Before(c.LockAt.Time) {
+		updates["lock_at"] = nil
+	}
+
+	return s.updateLock(ctx, id, updates, pkg.AuditUnlocked)
+}
+
+// setLocked will lock or unlock the competition and record it in the audit
+// log to keep the lock history.
+func (s *Service) setLocked(ctx context.Context, id int, locked bool, action string) error {
+	return s.updateLock(ctx, id, map[string]interface{}{"locked": locked}, action)
+}
+
+// updateLock will update the competition and record the action in the audit
+// log in the same transaction.
+func (s *Service) updateLock(ctx context.Context, id int, updates map[string]interface{}, action string) error {
+	tx := s.DB.Gorm.Begin()
+
+	if err := tx.Model(&pkg.Competition{ID: id}).Updates(updates).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "could not update competition lock")
+	}
+
+	if err := audit(ctx, tx, id, action, null.Int{}, nil); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.Wrap(err, "could not update competition lock")
+	}
+
+	return nil
+}
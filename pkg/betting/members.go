@@ -0,0 +1,149 @@
+package betting
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/bombsimon/team-betting/pkg"
+)
+
+// InviteMember will add a better as a member of a competition with the passed
+// role. Only the owner and co-hosts may invite members and only the owner may
+// invite co-hosts.
+func (s *Service) InviteMember(ctx context.Context, competitionID, betterID int, role string) (*pkg.CompetitionMember, error) {
+	if err := requireScope(ctx, pkg.ScopeAdmin, "invite members"); err != nil {
+		return nil, err
+	}
+
+	competition, err := s.getCompetition(competitionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if role == "" {
+		role = pkg.RoleParticipant
+	}
+
+	switch role {
+	case pkg.RoleParticipant:
+		err = requireRole(ctx, competition, "invite members", pkg.RoleOwner, pkg.RoleCoHost)
+	case pkg.RoleCoHost:
+		err = requireRole(ctx, competition, "invite co-hosts", pkg.RoleOwner)
+	default:
+		err = errors.Wrapf(pkg.ErrBadRequest, "invalid role %s", role)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.GetBetter(ctx, betterID); err != nil {
+		return nil, err
+	}
+
+	if currentRole := roleFor(betterID, competition); currentRole != "" {
+		return nil, errors.Wrapf(pkg.ErrBadRequest, "better is already a %s", currentRole)
+	}
+
+	return s.addMember(competitionID, betterID, role)
+}
+
+// SetMemberRole will promote or demote a member of a competition. Only the
+// owner may change roles and the owner role can't be given or taken away.
+func (s *Service) SetMemberRole(ctx context.Context, competitionID, betterID int, role string) (*pkg.CompetitionMember, error) {
+	if err := requireScope(ctx, pkg.ScopeAdmin, "change roles"); err != nil {
+		return nil, err
+	}
+
+	competition, err := s.getCompetition(competitionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireRole(ctx, competition, "change roles", pkg.RoleOwner); err != nil {
+		return nil, err
+	}
+
+	if role != pkg.RoleCoHost && role != pkg.RoleParticipant {
+		return nil, errors.Wrapf(pkg.ErrBadRequest, "invalid role %s", role)
+	}
+
+	member := memberFor(betterID, competition)
+
+	switch {
+	case member == nil:
+		return nil, errors.Wrap(pkg.ErrNotFound, "better is not a member of the competition")
+	case member.Role == pkg.RoleOwner || betterID == competition.CreatedByID:
+		return nil, errors.Wrap(pkg.ErrBadRequest, "the owner role can't be changed")
+	}
+
+	member.Role = role
+
+	if err := s.DB.Gorm.Model(member).Update("role", role).Error; err != nil {
+		return nil, errors.Wrap(err, "could not update member role")
+	}
+
+	return member, nil
+}
+
+// RemoveMember will remove a better from a competition. The owner may remove
+// any member except themselves and a member may always leave a competition.
+func (s *Service) RemoveMember(ctx context.Context, competitionID, betterID int) error {
+	if err := requireScope(ctx, pkg.ScopeAdmin, "remove members"); err != nil {
+		return err
+	}
+
+	competition, err := s.getCompetition(competitionID)
+	if err != nil {
+		return err
+	}
+
+	if err := requireOwner(ctx, betterID, "leave the competition"); err != nil {
+		if err := requireRole(ctx, competition, "remove members", pkg.RoleOwner); err != nil {
+			return err
+		}
+	}
+
+	member := memberFor(betterID, competition)
+
+	switch {
+	case member == nil:
+		return errors.Wrap(pkg.ErrNotFound, "better is not a member of the competition")
+	case member.Role == pkg.RoleOwner || betterID == competition.CreatedByID:
+		return errors.Wrap(pkg.ErrBadRequest, "the owner can't be removed")
+	}
+
+	if err := s.DB.Gorm.Delete(member).Error; err != nil {
+		return errors.Wrap(err, "could not remove member")
+	}
+
+	return nil
+}
+
+func (s *Service) addMember(competitionID, betterID int, role string) (*pkg.CompetitionMember, error) {
+	var member pkg.CompetitionMember
+
+	err := s.DB.Gorm.
+		Where(pkg.CompetitionMember{CompetitionID: competitionID, BetterID: betterID}).
+		Attrs(pkg.CompetitionMember{Role: role}).
+		FirstOrCreate(&member).
+		Error
+
+	if err != nil {
+		return nil, errors.Wrap(err, "could not add better to competition")
+	}
+
+	return &member, nil
+}
+
+// memberFor returns the membership for the better in the competition, if any.
+func memberFor(betterID int, competition *pkg.Competition) *pkg.CompetitionMember {
+	for _, m := range competition.Members {
+		if m.BetterID == betterID {
+			return m
+		}
+	}
+
+	return nil
+}
@@ -3,6 +3,8 @@ package betting
 import (
 	"context"
 
+	"github.com/pkg/errors"
+
 	"github.com/bombsimon/team-betting/pkg"
 )
 
@@ -22,11 +24,22 @@ type betterBets struct {
 
 //GetCompetitionMetrics returns metrics for a competition.
 func (s *Service) GetCompetitionMetrics(ctx context.Context, id int) (*pkg.CompetitionMetrics, error) {
-	competition, err := s.GetCompetition(ctx, id)
+	competition, err := s.getCompetition(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Metrics are calculated from all bets so they're not available until
+	// the bets are visible to everyone.
+	public, err := s.betsPublic([]*pkg.Competition{competition})
 	if err != nil {
 		return nil, err
 	}
 
+	if !public[competition.ID] {
+		return nil, errors.Wrap(pkg.ErrForbidden, "metrics are not available until bets are visible")
+	}
+
 	var (
 		cm                = &pkg.CompetitionMetrics{}
 		totalTopScores    = 0
@@ -92,6 +105,30 @@ func (s *Service) GetCompetitionMetrics(ctx context.Context, id int) (*pkg.Compe
 	cm.NumberOfTopScores = totalTopScores
 	cm.GroupAverageScore = float64(totalScore) / float64(totalBets)
 
+	// If the result is set we can also tell who made the best and worst
+	// prediction according to the scoring rule for the competition.
+	var results []*pkg.Result
+
+	if err := s.DB.Gorm.Where("competition_id = ?", id).Find(&results).Error; err != nil {
+		return nil, errors.Wrap(err, "could not get result for competition")
+	}
+
+	if len(results) == 0 {
+		return cm, nil
+	}
+
+	rule, err := ScoringRuleFor(competition.ScoringRule)
+	if err != nil {
+		return nil, err
+	}
+
+	if entries := leaderboard(competition.Bets, results, rule); len(entries) > 0 {
+		best, worst := entries[0], entries[len(entries)-1]
+
+		cm.BestPrediction = pkg.MetricValue{Who: best.Better, Value: best.Points}
+		cm.WorstPrediction = pkg.MetricValue{Who: worst.Better, Value: worst.Points}
+	}
+
 	return cm, nil
 }
 
@@ -0,0 +1,350 @@
+package betting
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/guregu/null"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+
+	"github.com/bombsimon/team-betting/pkg"
+	"github.com/bombsimon/team-betting/pkg/database"
+)
+
+const (
+	// oidcLoginTTL is how long a started login may take before the callback.
+	oidcLoginTTL = 10 * time.Minute
+
+	// oidcMaxLogins is the maximum number of started logins waiting for the
+	// callback. Starting a login is unauthenticated so they're limited to not
+	// run out of memory.
+	oidcMaxLogins = 10000
+)
+
+// OIDCConfig represents the configuration for signing in with an OpenID
+// Connect provider.
+type OIDCConfig struct {
+	Issuer         string
+	ClientID       string
+	ClientSecret   string
+	RedirectURL    string
+	AllowedDomains []string
+}
+
+// OIDCConfigFromEnv creates an OpenID Connect configuration from the
+// environment. Nil is returned if no issuer is configured.
+//
+//  OIDC_ISSUER            the issuer URL of the provider
+//  OIDC_CLIENT_ID         the client ID registered at the provider
+//  OIDC_CLIENT_SECRET     the client secret, if the client has one
+//  OIDC_REDIRECT_URL      the URL the provider redirects back to
+//  OIDC_ALLOWED_DOMAINS   comma separated email domains allowed to sign in
+func OIDCConfigFromEnv() *OIDCConfig {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil
+	}
+
+	config := &OIDCConfig{
+		Issuer:       issuer,
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+	}
+
+	for _, domain := range strings.Split(os.Getenv("OIDC_ALLOWED_DOMAINS"), ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			config.AllowedDomains = append(config.AllowedDomains, strings.ToLower(domain))
+		}
+	}
+
+	return config
+}
+
+// OIDCProvider signs in betters with an OpenID Connect provider using the
+// authorization code flow with PKCE. Started logins are kept in memory so the
+// callback must reach the same instance that started the login.
+type OIDCProvider struct {
+	Config *OIDCConfig
+
+	client   *http.Client
+	oauth2   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+
+	mu     sync.Mutex
+	logins map[string]*oidcLogin
+}
+
+// oidcLogin represents a started login waiting for the callback.
+type oidcLogin struct {
+	verifier  string
+	nonce     string
+	expiresAt time.Time
+}
+
+// oidcIdentity represents the verified identity of a signed in user.
+type oidcIdentity struct {
+	Subject string
+	Email   string
+	Name    string
+}
+
+// NewOIDCProvider creates a new provider with the endpoints from the issuer's
+// discovery document.
+func NewOIDCProvider(ctx context.Context, config *OIDCConfig) (*OIDCProvider, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	// The context is kept by the provider to fetch its keys so it's used for
+	// the client only, not to cancel anything.
+	provider, err := oidc.NewProvider(oidc.ClientContext(ctx, client), config.Issuer)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get OIDC discovery document")
+	}
+
+	return &OIDCProvider{
+		Config: config,
+		client: client,
+		oauth2: &oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: config.ClientID}),
+		logins:   map[string]*oidcLogin{},
+	}, nil
+}
+
+// OIDCAuthURL returns the URL to redirect the user to to sign in at the OpenID
+// Connect provider.
+func (s *Service) OIDCAuthURL(ctx context.Context) (string, error) {
+	if s.OIDC == nil {
+		return "", errors.Wrap(pkg.ErrNotFound, "sign in with OpenID Connect is not configured")
+	}
+
+	return s.OIDC.authURL()
+}
+
+// SignInFromOIDC will exchange the authorization code from the OpenID Connect
+// provider and return a JWT and a refresh token for the better with the
+// verified email. A better is created if no better with the email exists.
+func (s *Service) SignInFromOIDC(ctx context.Context, code, state string) (*pkg.Tokens, error) {
+	if s.OIDC == nil {
+		return nil, errors.Wrap(pkg.ErrNotFound, "sign in with OpenID Connect is not configured")
+	}
+
+	identity, err := s.OIDC.exchange(ctx, code, state)
+	if err != nil {
+		return nil, err
+	}
+
+	better, err := s.betterForIdentity(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.signIn(ctx, better)
+}
+
+// betterForIdentity returns the better with the same email as the identity.
+// The better is created if it doesn't exist.
+//
+// Anyone may create an unconfirmed better with any email so when such a
+// better is confirmed everything issued to it before is revoked. Otherwise
+// whoever created the better would keep access to the account.
+func (s *Service) betterForIdentity(identity *oidcIdentity) (*pkg.Better, error) {
+	var better pkg.Better
+
+	err := s.DB.Gorm.Where("LOWER(email) = ?", identity.Email).First(&better).Error
+
+	switch {
+	case err == nil:
+		if !better.Confirmed {
+			if err := s.confirmBetter(&better); err != nil {
+				return nil, err
+			}
+		}
+
+		return &better, nil
+	case !gorm.IsRecordNotFoundError(err):
+		return nil, errors.Wrap(err, "could not get better")
+	}
+
+	better = pkg.Better{
+		Name:      identity.Name,
+		Email:     null.StringFrom(identity.Email),
+		Confirmed: true,
+	}
+
+	if better.Name == "" {
+		better.Name = strings.SplitN(identity.Email, "@", 2)[0]
+	}
+
+	if err := better.Validate(); err != nil {
+		return nil, errors.Wrap(err, "bad request")
+	}
+
+	if err := s.DB.Gorm.Save(&better).Error; err != nil {
+		// Someone else signed in with the same email at the same time.
+		if database.ErrType(err) == database.ErrDuplicateKey {
+			return s.betterForIdentity(identity)
+		}
+
+		return nil, errors.Wrap(err, "could not create better")
+	}
+
+	return &better, nil
+}
+
+// confirmBetter will confirm the better and revoke all tokens issued to the
+// better before it was confirmed.
+func (s *Service) confirmBetter(better *pkg.Better) error {
+	tx := s.DB.Gorm.Begin()
+
+	if err := tx.Model(better).Update("confirmed", true).Error; err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "could not confirm better")
+	}
+
+	if err := revokeSessions(tx, better.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.Wrap(err, "could not confirm better")
+	}
+
+	s.versions.invalidate(better.ID)
+
+	better.Confirmed = true
+	better.TokenVersion++
+
+	return nil
+}
+
+// authURL will start a new login and return the URL to the provider.
+func (p *OIDCProvider) authURL() (string, error) {
+	state, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	nonce, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	verifier := oauth2.GenerateVerifier()
+
+	p.mu.Lock()
+
+	now := time.Now()
+	for k, login := range p.logins {
+		if login.expiresAt.Before(now) {
+			delete(p.logins, k)
+		}
+	}
+
+	if len(p.logins) >= oidcMaxLogins {
+		p.mu.Unlock()
+		return "", errors.Wrap(pkg.ErrBadRequest, "too many logins in progress, try again later")
+	}
+
+	p.logins[state] = &oidcLogin{
+		verifier:  verifier,
+		nonce:     nonce,
+		expiresAt: now.Add(oidcLoginTTL),
+	}
+
+	p.mu.Unlock()
+
+	return p.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+// exchange will exchange the authorization code for an ID token and return the
+// verified identity. Each login may only be completed once.
+func (p *OIDCProvider) exchange(ctx context.Context, code, state string) (*oidcIdentity, error) {
+	p.mu.Lock()
+	login, ok := p.logins[state]
+	delete(p.logins, state)
+	p.mu.Unlock()
+
+	if !ok || login.expiresAt.Before(time.Now()) {
+		return nil, errors.Wrap(pkg.ErrBadRequest, "invalid or expired login")
+	}
+
+	ctx = oidc.ClientContext(ctx, p.client)
+
+	token, err := p.oauth2.Exchange(ctx, code, oauth2.VerifierOption(login.verifier))
+	if err != nil {
+		return nil, errors.Wrapf(pkg.ErrUnauthorized, "could not exchange authorization code: %s", err.Error())
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, errors.Wrap(pkg.ErrUnauthorized, "no ID token in token response")
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, errors.Wrapf(pkg.ErrUnauthorized, "could not verify ID token: %s", err.Error())
+	}
+
+	if idToken.Nonce != login.nonce {
+		return nil, errors.Wrap(pkg.ErrUnauthorized, "invalid nonce in ID token")
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, errors.Wrapf(pkg.ErrUnauthorized, "could not get ID token claims: %s", err.Error())
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errors.Wrap(pkg.ErrForbidden, "email is not verified")
+	}
+
+	email := strings.ToLower(claims.Email)
+
+	if !p.allowedEmail(email) {
+		return nil, errors.Wrapf(pkg.ErrForbidden, "email domain for %s is not allowed", email)
+	}
+
+	return &oidcIdentity{
+		Subject: idToken.Subject,
+		Email:   email,
+		Name:    claims.Name,
+	}, nil
+}
+
+func (p *OIDCProvider) allowedEmail(email string) bool {
+	if len(p.Config.AllowedDomains) == 0 {
+		return true
+	}
+
+	parts := strings.SplitN(email, "@", 2)
+	if len(parts) != 2 {
+		return false
+	}
+
+	for _, domain := range p.Config.AllowedDomains {
+		if parts[1] == domain {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,61 @@
+package betting
+
+import (
+	"context"
+	"time"
+
+	"github.com/guregu/null"
+	"github.com/pkg/errors"
+
+	"github.com/bombsimon/team-betting/pkg"
+)
+
+// GetScheduledLocks returns all competitions not yet locked that has a time
+// set when they should be locked.
+func (s *Service) GetScheduledLocks(ctx context.Context) ([]*pkg.Competition, error) {
+	var competitions []*pkg.Competition
+
+	err := s.DB.Gorm.
+		Where("locked = ? AND lock_at IS NOT NULL", false).
+		Find(&competitions).
+		Error
+
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get scheduled locks")
+	}
+
+	return competitions, nil
+}
+
+// LockScheduledCompetition will lock the competition if the time it's
+// scheduled to be locked has passed. The returned value tells if the
+// competition was locked by this call, it's false if the competition was
+// already locked or if the lock has been rescheduled.
+func (s *Service) LockScheduledCompetition(ctx context.Context, id int) (bool, error) {
+	tx := s.DB.Gorm.Begin()
+
+	r := tx.Model(&pkg.Competition{}).
+		Where("id = ? AND locked = ? AND lock_at <= ?", id, false, time.Now()).
+		UpdateColumn("locked", true)
+
+	if r.Error != nil {
+		tx.Rollback()
+		return false, errors.Wrap(r.Error, "could not lock competition")
+	}
+
+	if r.RowsAffected != 1 {
+		tx.Rollback()
+		return false, nil
+	}
+
+	if err := audit(ctx, tx, id, pkg.AuditLocked, null.Int{}, map[string]bool{"scheduled": true}); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return false, errors.Wrap(err, "could not lock competition")
+	}
+
+	return true, nil
+}
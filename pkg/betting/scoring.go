@@ -0,0 +1,227 @@
+package betting
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+
+	"github.com/bombsimon/team-betting/pkg"
+)
+
+// eurovisionPoints is the points handed out for the top ten placings in the
+// Eurovision Song Contest.
+var eurovisionPoints = []float64{12, 10, 8, 7, 6, 5, 4, 3, 2, 1}
+
+// scoringRules holds all the built in scoring rules by name.
+var scoringRules = map[string]pkg.ScoringRule{
+	pkg.ScoringExact:      &ExactRule{},
+	pkg.ScoringDistance:   &DistanceRule{},
+	pkg.ScoringEurovision: &EurovisionRule{},
+	pkg.ScoringSpearman:   &SpearmanRule{},
+	pkg.ScoringKendall:    &KendallRule{},
+}
+
+// ScoringRuleFor returns the scoring rule with the given name. If no name is
+// set the default scoring rule will be returned.
+func ScoringRuleFor(name string) (pkg.ScoringRule, error) {
+	if name == "" {
+		name = pkg.ScoringDefault
+	}
+
+	rule, ok := scoringRules[name]
+	if !ok {
+		return nil, errors.Wrapf(pkg.ErrBadRequest, "unknown scoring rule %s", name)
+	}
+
+	return rule, nil
+}
+
+// ExactRule gives one point for every competitor placed exactly right.
+type ExactRule struct{}
+
+// Score implements the ScoringRule interface.
+func (*ExactRule) Score(predicted, actual map[int]int) float64 {
+	var points float64
+
+	for competitorID, placing := range predicted {
+		if actualPlacing, ok := actual[competitorID]; ok && placing == actualPlacing {
+			points++
+		}
+	}
+
+	return points
+}
+
+// DistanceRule gives as many points as there are placed competitors for each
+// prediction and subtracts the absolute distance between the predicted and the
+// actual placing. A prediction never gives less than zero points.
+type DistanceRule struct{}
+
+// Score implements the ScoringRule interface.
+func (*DistanceRule) Score(predicted, actual map[int]int) float64 {
+	var points float64
+
+	for competitorID, placing := range predicted {
+		actualPlacing, ok := actual[competitorID]
+		if !ok {
+			continue
+		}
+
+		points += math.Max(0, float64(len(actual))-math.Abs(float64(placing-actualPlacing)))
+	}
+
+	return points
+}
+
+// EurovisionRule hands out 12, 10, 8, 7 down to 1 points to the top ten just
+// like a Eurovision jury, both for the prediction and the actual result. The
+// score is the sum of the points that overlaps for each competitor, i.e. the
+// lowest of the predicted and the actual points.
+type EurovisionRule struct{}
+
+// Score implements the ScoringRule interface.
+func (*EurovisionRule) Score(predicted, actual map[int]int) float64 {
+	var points float64
+
+	for competitorID, placing := range predicted {
+		actualPlacing, ok := actual[competitorID]
+		if !ok {
+			continue
+		}
+
+		points += math.Min(eurovisionPointsFor(placing), eurovisionPointsFor(actualPlacing))
+	}
+
+	return points
+}
+
+func eurovisionPointsFor(placing int) float64 {
+	if placing < 1 || placing > len(eurovisionPoints) {
+		return 0
+	}
+
+	return eurovisionPoints[placing-1]
+}
+
+// SpearmanRule scores the prediction with Spearman's rank correlation
+// coefficient between the predicted and the actual placings. The score ranges
+// from -1 (completely reversed) to 1 (perfect prediction).
+type SpearmanRule struct{}
+
+// Score implements the ScoringRule interface.
+func (*SpearmanRule) Score(predicted, actual map[int]int) float64 {
+	x, y := pairedPlacings(predicted, actual)
+	if len(x) < 2 {
+		return 0
+	}
+
+	// Calculate the Pearson correlation between the ranks which gives the
+	// Spearman correlation and handles tied ranks.
+	x, y = fractionalRanks(x), fractionalRanks(y)
+
+	var (
+		n            = float64(len(x))
+		meanX, meanY float64
+	)
+
+	for i := range x {
+		meanX += x[i] / n
+		meanY += y[i] / n
+	}
+
+	var cov, varX, varY float64
+
+	for i := range x {
+		cov += (x[i] - meanX) * (y[i] - meanY)
+		varX += (x[i] - meanX) * (x[i] - meanX)
+		varY += (y[i] - meanY) * (y[i] - meanY)
+	}
+
+	if varX == 0 || varY == 0 {
+		return 0
+	}
+
+	return cov / math.Sqrt(varX*varY)
+}
+
+// KendallRule scores the prediction with Kendall's rank correlation
+// coefficient (tau-b) between the predicted and the actual placings. The score
+// ranges from -1 (completely reversed) to 1 (perfect prediction).
+type KendallRule struct{}
+
+// Score implements the ScoringRule interface.
+func (*KendallRule) Score(predicted, actual map[int]int) float64 {
+	x, y := pairedPlacings(predicted, actual)
+	if len(x) < 2 {
+		return 0
+	}
+
+	var concordant, discordant, tiesX, tiesY float64
+
+	for i := 0; i < len(x); i++ {
+		for j := i + 1; j < len(x); j++ {
+			dx, dy := x[i]-x[j], y[i]-y[j]
+
+			switch {
+			case dx == 0 && dy == 0:
+				// Tied in both, counts for neither.
+			case dx == 0:
+				tiesX++
+			case dy == 0:
+				tiesY++
+			case (dx > 0) == (dy > 0):
+				concordant++
+			default:
+				discordant++
+			}
+		}
+	}
+
+	denominator := math.Sqrt((concordant + discordant + tiesX) * (concordant + discordant + tiesY))
+	if denominator == 0 {
+		return 0
+	}
+
+	return (concordant - discordant) / denominator
+}
+
+// pairedPlacings returns the predicted and actual placings for all competitors
+// that exist in both the prediction and the result.
+func pairedPlacings(predicted, actual map[int]int) ([]float64, []float64) {
+	var x, y []float64
+
+	for competitorID, placing := range predicted {
+		actualPlacing, ok := actual[competitorID]
+		if !ok {
+			continue
+		}
+
+		x = append(x, float64(placing))
+		y = append(y, float64(actualPlacing))
+	}
+
+	return x, y
+}
+
+// fractionalRanks converts the values to ranks where tied values get the
+// average of the ranks they span.
+func fractionalRanks(values []float64) []float64 {
+	ranks := make([]float64, len(values))
+
+	for i, v := range values {
+		var lower, equal float64
+
+		for _, other := range values {
+			switch {
+			case other < v:
+				lower++
+			case other == v:
+				equal++
+			}
+		}
+
+		ranks[i] = lower + (equal+1)/2
+	}
+
+	return ranks
+}
@@ -0,0 +1,163 @@
+package betting
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+
+	"github.com/bombsimon/team-betting/pkg"
+)
+
+// tokenVersionTTL is how long a token version is cached. A token revoked by
+// another instance of the service may be accepted for this long.
+const tokenVersionTTL = 30 * time.Second
+
+// tokenVersionCache caches the current token version for betters so the
+// database doesn't have to be queried for every authenticated request. The
+// zero value is ready to use.
+type tokenVersionCache struct {
+	mu      sync.Mutex
+	entries map[int]tokenVersionEntry
+}
+
+type tokenVersionEntry struct {
+	version   int
+	deleted   bool
+	expiresAt time.Time
+}
+
+func (c *tokenVersionCache) get(betterID int) (tokenVersionEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[betterID]
+	if !ok || entry.expiresAt.Before(time.Now()) {
+		return tokenVersionEntry{}, false
+	}
+
+	return entry, true
+}
+
+func (c *tokenVersionCache) set(betterID int, entry tokenVersionEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = map[int]tokenVersionEntry{}
+	}
+
+	// Remove expired entries while we're holding the lock so the cache doesn't
+	// grow with betters who no longer makes requests.
+	now := time.Now()
+	for id, e := range c.entries {
+		if e.expiresAt.Before(now) {
+			delete(c.entries, id)
+		}
+	}
+
+	entry.expiresAt = now.Add(tokenVersionTTL)
+	c.entries[betterID] = entry
+}
+
+func (c *tokenVersionCache) invalidate(betterID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, betterID)
+}
+
+// SignOutEverywhere will bump the token version for the better which
+// invalidates every JWT issued to the better and revoke all refresh tokens and
+// API tokens, i.e. sign out the better from every device and script. Only the
+// better may sign out themselves.
+func (s *Service) SignOutEverywhere(ctx context.Context, betterID int) error {
+	if err := requireSession(ctx, "sign out betters"); err != nil {
+		return err
+	}
+
+	if err := requireOwner(ctx, betterID, "sign out the better"); err != nil {
+		return err
+	}
+
+	tx := s.DB.Gorm.Begin()
+
+	if err := revokeSessions(tx, betterID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return errors.Wrap(err, "could not sign out better")
+	}
+
+	s.versions.invalidate(betterID)
+
+	return nil
+}
+
+// revokeSessions will, using the passed database handle, bump the token
+// version for the better and revoke all refresh tokens and API tokens so
+// nothing issued to the better before can be used anymore. The token version
+// cache must be invalidated once the changes are committed.
+func revokeSessions(db *gorm.DB, betterID int) error {
+	err := db.Model(&pkg.Better{ID: betterID}).
+		UpdateColumn("token_version", gorm.Expr("token_version + 1")).
+		Error
+
+	if err != nil {
+		return errors.Wrap(err, "could not update token version")
+	}
+
+	now := time.Now()
+
+	err = db.Model(&pkg.RefreshToken{}).
+		Where("better_id = ? AND revoked_at IS NULL", betterID).
+		Update("revoked_at", now).
+		Error
+
+	if err != nil {
+		return errors.Wrap(err, "could not revoke refresh tokens")
+	}
+
+	err = db.Model(&pkg.APIToken{}).
+		Where("better_id = ? AND revoked_at IS NULL", betterID).
+		Update("revoked_at", now).
+		Error
+
+	if err != nil {
+		return errors.Wrap(err, "could not revoke API tokens")
+	}
+
+	return nil
+}
+
+// tokenVersion returns the current token version for the better. An error is
+// returned if the better no longer exists.
+func (s *Service) tokenVersion(betterID int) (int, error) {
+	entry, ok := s.versions.get(betterID)
+	if !ok {
+		var better pkg.Better
+
+		err := s.DB.Gorm.Select("id, token_version").Where("id = ?", betterID).First(&better).Error
+
+		switch {
+		case gorm.IsRecordNotFoundError(err):
+			entry.deleted = true
+		case err != nil:
+			return 0, errors.Wrap(err, "could not get token version")
+		default:
+			entry.version = better.TokenVersion
+		}
+
+		s.versions.set(betterID, entry)
+	}
+
+	if entry.deleted {
+		return 0, errors.Wrap(pkg.ErrUnauthorized, "better no longer exists")
+	}
+
+	return entry.version, nil
+}
@@ -0,0 +1,156 @@
+package betting
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/bombsimon/team-betting/pkg"
+)
+
+// DefaultRefreshTokenTTL is how long a refresh token is valid if not
+// configured on the service.
+const DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+
+// RefreshTokens will exchange a refresh token for a new JWT and a new refresh
+// token. A refresh token may only be used once and using it again revokes all
+// tokens issued from the same sign in since the token has probably leaked.
+func (s *Service) RefreshTokens(ctx context.Context, refreshToken string) (*pkg.Tokens, error) {
+	var rt pkg.RefreshToken
+
+	if s.DB.Gorm.Where("token_hash = ?", hashToken(refreshToken)).First(&rt).RecordNotFound() {
+		return nil, errors.Wrap(pkg.ErrUnauthorized, "invalid refresh token")
+	}
+
+	if rt.RevokedAt.Valid {
+		return nil, errors.Wrap(pkg.ErrUnauthorized, "refresh token has been revoked")
+	}
+
+	if rt.UsedAt.Valid {
+		if err := s.revokeRefreshTokenFamily(rt.FamilyID); err != nil {
+			return nil, err
+		}
+
+		return nil, errors.Wrap(pkg.ErrUnauthorized, "refresh token reuse detected")
+	}
+
+	if rt.ExpiresAt.Before(time.Now()) {
+		return nil, errors.Wrap(pkg.ErrUnauthorized, "refresh token has expired")
+	}
+
+	// Only mark the token as used if no one else did it before us so two
+	// concurrent requests with the same token can't both succeed.
+	r := s.DB.Gorm.Model(&pkg.RefreshToken{}).
+		Where("id = ? AND used_at IS NULL", rt.ID).
+		Update("used_at", time.Now())
+
+	if r.Error != nil {
+		return nil, errors.Wrap(r.Error, "could not use refresh token")
+	}
+
+	if r.RowsAffected == 0 {
+		if err := s.revokeRefreshTokenFamily(rt.FamilyID); err != nil {
+			return nil, err
+		}
+
+		return nil, errors.Wrap(pkg.ErrUnauthorized, "refresh token reuse detected")
+	}
+
+	better, err := s.GetBetter(ctx, rt.BetterID)
+	if err != nil {
+		return nil, errors.Wrap(pkg.ErrUnauthorized, "better for refresh token not found")
+	}
+
+	return s.issueTokens(ctx, better, rt.FamilyID)
+}
+
+// RevokeRefreshToken will revoke the refresh token and all other tokens issued
+// from the same sign in, i.e. sign out the device using the token.
+func (s *Service) RevokeRefreshToken(ctx context.Context, refreshToken string) error {
+	var rt pkg.RefreshToken
+
+	if s.DB.Gorm.Where("token_hash = ?", hashToken(refreshToken)).First(&rt).RecordNotFound() {
+		return errors.Wrap(pkg.ErrUnauthorized, "invalid refresh token")
+	}
+
+	return s.revokeRefreshTokenFamily(rt.FamilyID)
+}
+
+// signIn will issue a JWT and a refresh token for a new sign in.
+func (s *Service) signIn(ctx context.Context, better *pkg.Better) (*pkg.Tokens, error) {
+	return s.issueTokens(ctx, better, uuid.New().String())
+}
+
+// issueTokens will issue a JWT and a refresh token in the passed family. All
+// refresh tokens rotated from the same sign in share the same family.
+func (s *Service) issueTokens(ctx context.Context, better *pkg.Better, familyID string) (*pkg.Tokens, error) {
+	jwtString, err := s.JWTForBetter(ctx, better)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create JWT")
+	}
+
+	refreshToken, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := s.RefreshTokenTTL
+	if ttl == 0 {
+		ttl = DefaultRefreshTokenTTL
+	}
+
+	rt := pkg.RefreshToken{
+		BetterID:  better.ID,
+		TokenHash: hashToken(refreshToken),
+		FamilyID:  familyID,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+
+	if err := s.DB.Gorm.Create(&rt).Error; err != nil {
+		return nil, errors.Wrap(err, "could not save refresh token")
+	}
+
+	return &pkg.Tokens{
+		JWT:                   jwtString,
+		RefreshToken:          refreshToken,
+		RefreshTokenExpiresAt: rt.ExpiresAt,
+	}, nil
+}
+
+func (s *Service) revokeRefreshTokenFamily(familyID string) error {
+	err := s.DB.Gorm.Model(&pkg.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).
+		Error
+
+	if err != nil {
+		return errors.Wrap(err, "could not revoke refresh tokens")
+	}
+
+	return nil
+}
+
+// randomToken returns a random, URL safe token.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "could not generate token")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hash for a token. Only the hash is stored so a leaked
+// database can't be used to sign in.
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(h[:])
+}
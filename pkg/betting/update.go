@@ -0,0 +1,206 @@
+package betting
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"github.com/bombsimon/team-betting/pkg"
+)
+
+// UpdateCompetition will update the fields in the patch for a competition. The
+// score range can't be changed so that existing bets are outside of it. Only
+// the owner and co-hosts of the competition may update it.
+func (s *Service) UpdateCompetition(ctx context.Context, id int, patch pkg.Patch) (*pkg.Competition, error) {
+	if err := requireScope(ctx, pkg.ScopeAdmin, "update competitions"); err != nil {
+		return nil, err
+	}
+
+	c, err := s.getCompetition(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireRole(ctx, c, "update the competition", pkg.RoleOwner, pkg.RoleCoHost); err != nil {
+		return nil, err
+	}
+
+	updated := *c
+
+	if err := applyPatch(patch, &updated, competitionColumns(&updated)); err != nil {
+		return nil, err
+	}
+
+	if err := updated.Validate(); err != nil {
+		return nil, errors.Wrap(err, "bad request")
+	}
+
+	if updated.MinScore > updated.MaxScore {
+		return nil, errors.Wrap(pkg.ErrBadRequest, "min_score must not be greater than max_score")
+	}
+
+	if updated.MinScore != c.MinScore || updated.MaxScore != c.MaxScore {
+		var outside int
+
+		err := s.DB.Gorm.Model(&pkg.Bet{}).
+			Where("competition_id = ? AND (score < ? OR score > ?)", id, updated.MinScore, updated.MaxScore).
+			Count(&outside).
+			Error
+
+		if err != nil {
+			return nil, errors.Wrap(err, "could not get bets for competition")
+		}
+
+		if outside > 0 {
+			return nil, errors.Wrapf(pkg.ErrBadRequest, "%d bets has a score outside the new score range", outside)
+		}
+	}
+
+	columns := patchedColumns(patch, competitionColumns(&updated))
+
+	if err := s.DB.Gorm.Model(&pkg.Competition{ID: id}).Updates(columns).Error; err != nil {
+		return nil, errors.Wrap(err, "could not update competition")
+	}
+
+	return s.GetCompetition(ctx, id)
+}
+
+// UpdateCompetitor will update the fields in the patch for a competitor. Only
+// the better who created the competitor may update it.
+func (s *Service) UpdateCompetitor(ctx context.Context, id int, patch pkg.Patch) (*pkg.Competitor, error) {
+	if err := requireScope(ctx, pkg.ScopeAdmin, "update competitors"); err != nil {
+		return nil, err
+	}
+
+	c, err := s.GetCompetitor(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireOwner(ctx, c.CreatedByID, "update the competitor"); err != nil {
+		return nil, err
+	}
+
+	updated := *c
+
+	if err := applyPatch(patch, &updated, competitorColumns(&updated)); err != nil {
+		return nil, err
+	}
+
+	if err := updated.Validate(); err != nil {
+		return nil, errors.Wrap(err, "bad request")
+	}
+
+	columns := patchedColumns(patch, competitorColumns(&updated))
+
+	if err := s.DB.Gorm.Model(&pkg.Competitor{ID: id}).Updates(columns).Error; err != nil {
+		return nil, errors.Wrap(err, "could not update competitor")
+	}
+
+	return s.GetCompetitor(ctx, id)
+}
+
+// UpdateBetter will update the fields in the patch for a better. The email
+// can't be changed since it's used to sign in. A better may only update
+// themselves.
+func (s *Service) UpdateBetter(ctx context.Context, id int, patch pkg.Patch) (*pkg.Better, error) {
+	if err := requireSession(ctx, "update betters"); err != nil {
+		return nil, err
+	}
+
+	if err := requireOwner(ctx, id, "update the better"); err != nil {
+		return nil, err
+	}
+
+	b, err := s.GetBetter(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := *b
+
+	if err := applyPatch(patch, &updated, betterColumns(&updated)); err != nil {
+		return nil, err
+	}
+
+	if err := updated.Validate(); err != nil {
+		return nil, errors.Wrap(err, "bad request")
+	}
+
+	columns := patchedColumns(patch, betterColumns(&updated))
+
+	if err := s.DB.Gorm.Model(&pkg.Better{ID: id}).Updates(columns).Error; err != nil {
+		return nil, errors.Wrap(err, "could not update better")
+	}
+
+	return s.GetBetter(ctx, id)
+}
+
+// competitionColumns returns the columns that may be updated for a competition
+// and their values.
+func competitionColumns(c *pkg.Competition) map[string]interface{} {
+	return map[string]interface{}{
+		"name":           c.Name,
+		"description":    c.Description,
+		"image":          c.Image,
+		"min_score":      c.MinScore,
+		"max_score":      c.MaxScore,
+		"scoring_rule":   c.ScoringRule,
+		"bet_visibility": c.BetVisibility,
+		"opens_at":       c.OpensAt,
+		"lock_at":        c.LockAt,
+	}
+}
+
+// competitorColumns returns the columns that may be updated for a competitor
+// and their values.
+func competitorColumns(c *pkg.Competitor) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        c.Name,
+		"description": c.Description,
+		"image":       c.Image,
+	}
+}
+
+// betterColumns returns the columns that may be updated for a better and their
+// values.
+func betterColumns(b *pkg.Better) map[string]interface{} {
+	return map[string]interface{}{
+		"name":  b.Name,
+		"image": b.Image,
+	}
+}
+
+// applyPatch will set the fields in the patch on the target. Only fields in
+// the editable columns may be patched, the JSON field names are the same as
+// the column names.
+func applyPatch(patch pkg.Patch, target interface{}, editable map[string]interface{}) error {
+	for field := range patch {
+		if _, ok := editable[field]; !ok {
+			return errors.Wrapf(pkg.ErrBadRequest, "%s may not be updated", field)
+		}
+	}
+
+	b, err := json.Marshal(patch)
+	if err != nil {
+		return errors.Wrap(err, "could not marshal patch")
+	}
+
+	if err := json.Unmarshal(b, target); err != nil {
+		return errors.Wrap(pkg.ErrBadRequest, err.Error())
+	}
+
+	return nil
+}
+
+// patchedColumns returns the columns for the fields in the patch.
+func patchedColumns(patch pkg.Patch, columns map[string]interface{}) map[string]interface{} {
+	patched := map[string]interface{}{}
+
+	for field := range patch {
+		patched[field] = columns[field]
+	}
+
+	return patched
+}
@@ -0,0 +1,138 @@
+package betting
+
+import (
+	"context"
+
+	"github.com/guregu/null"
+	"github.com/pkg/errors"
+
+	"github.com/bombsimon/team-betting/pkg"
+)
+
+// VisibleBet returns the bet as seen by the better making the request, i.e.
+// with the score, placing and note hidden if the better may not see it yet.
+func (s *Service) VisibleBet(ctx context.Context, bet *pkg.Bet) (*pkg.Bet, error) {
+	visible := *bet
+
+	if err := s.hideBets(ctx, []*pkg.Bet{&visible}); err != nil {
+		return nil, err
+	}
+
+	return &visible, nil
+}
+
+// hideCompetitionBets will hide the bets in the competitions that the better
+// making the request may not see yet.
+func (s *Service) hideCompetitionBets(ctx context.Context, competitions []*pkg.Competition) error {
+	public, err := s.betsPublic(competitions)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range competitions {
+		if !public[c.ID] {
+			hideOthersBets(ctx, c.Bets)
+		}
+	}
+
+	return nil
+}
+
+// hideBets will hide the bets the better making the request may not see yet.
+func (s *Service) hideBets(ctx context.Context, bets []*pkg.Bet) error {
+	if len(bets) == 0 {
+		return nil
+	}
+
+	competitionIDs := []int{}
+	for _, bet := range bets {
+		competitionIDs = append(competitionIDs, bet.CompetitionID)
+	}
+
+	var competitions []*pkg.Competition
+
+	err := s.DB.Gorm.
+		Select("id, locked, bet_visibility").
+		Where("id IN (?)", competitionIDs).
+		Find(&competitions).
+		Error
+
+	if err != nil {
+		return errors.Wrap(err, "could not get competitions for bets")
+	}
+
+	public, err := s.betsPublic(competitions)
+	if err != nil {
+		return err
+	}
+
+	var hidden []*pkg.Bet
+
+	for _, bet := range bets {
+		if !public[bet.CompetitionID] {
+			hidden = append(hidden, bet)
+		}
+	}
+
+	hideOthersBets(ctx, hidden)
+
+	return nil
+}
+
+// betsPublic returns if the bets in each competition are visible to everyone
+// based on the bet visibility and the state of the competition.
+func (s *Service) betsPublic(competitions []*pkg.Competition) (map[int]bool, error) {
+	var (
+		public      = map[int]bool{}
+		afterResult = []int{}
+	)
+
+	for _, c := range competitions {
+		switch c.BetVisibility {
+		case pkg.BetVisibilityAfterLock:
+			public[c.ID] = c.Locked
+		case pkg.BetVisibilityAfterResult:
+			afterResult = append(afterResult, c.ID)
+		default:
+			public[c.ID] = true
+		}
+	}
+
+	if len(afterResult) == 0 {
+		return public, nil
+	}
+
+	var withResult []int
+
+	err := s.DB.Gorm.Model(&pkg.Result{}).
+		Where("competition_id IN (?)", afterResult).
+		Pluck("DISTINCT competition_id", &withResult).
+		Error
+
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get results for competitions")
+	}
+
+	for _, id := range withResult {
+		public[id] = true
+	}
+
+	return public, nil
+}
+
+// hideOthersBets will hide the score, placing and note for all bets not placed
+// by the better making the request.
+func hideOthersBets(ctx context.Context, bets []*pkg.Bet) {
+	better, _ := pkg.BetterFromContext(ctx)
+
+	for _, bet := range bets {
+		if better != nil && bet.BetterID == better.ID {
+			continue
+		}
+
+		bet.Score = null.Int{}
+		bet.Placing = null.Int{}
+		bet.Note = null.String{}
+		bet.Hidden = true
+	}
+}
@@ -0,0 +1,53 @@
+package pkg
+
+import "context"
+
+type contextKey int
+
+const (
+	betterContextKey contextKey = iota
+	scopeContextKey
+	lockOverrideContextKey
+)
+
+// ContextWithBetter returns a new context carrying the better making the
+// request.
+func ContextWithBetter(ctx context.Context, better *Better) context.Context {
+	return context.WithValue(ctx, betterContextKey, better)
+}
+
+// BetterFromContext returns the better making the request, if any.
+func BetterFromContext(ctx context.Context) (*Better, bool) {
+	better, ok := ctx.Value(betterContextKey).(*Better)
+
+	return better, ok && better != nil
+}
+
+// ContextWithScope returns a new context carrying the scope of the API token
+// used for the request. Requests authenticated with a JWT has no scope and may
+// do everything the better may do.
+func ContextWithScope(ctx context.Context, scope string) context.Context {
+	return context.WithValue(ctx, scopeContextKey, scope)
+}
+
+// ScopeFromContext returns the scope of the API token used for the request, if
+// any.
+func ScopeFromContext(ctx context.Context) (string, bool) {
+	scope, ok := ctx.Value(scopeContextKey).(string)
+
+	return scope, ok && scope != ""
+}
+
+// ContextWithLockOverride returns a new context where the owner of a
+// competition asks to override the lock, or the window when bets may be
+// placed, to correct bets.
+func ContextWithLockOverride(ctx context.Context) context.Context {
+	return context.WithValue(ctx, lockOverrideContextKey, true)
+}
+
+// LockOverrideFromContext returns if the lock should be overridden.
+func LockOverrideFromContext(ctx context.Context) bool {
+	override, _ := ctx.Value(lockOverrideContextKey).(bool)
+
+	return override
+}
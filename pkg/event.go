@@ -0,0 +1,68 @@
+package pkg
+
+import "time"
+
+// EventVersion is the current version of the event envelope. It should be
+// bumped on any breaking change to the envelope or the payloads.
+const EventVersion = 1
+
+// EventType represents the kind of a realtime event.
+type EventType string
+
+// All known realtime event types.
+const (
+	EventBetUpserted              EventType = "bet.upserted"
+	EventBetDeleted               EventType = "bet.deleted"
+	EventCompetitionLocked        EventType = "competition.locked"
+	EventCompetitionUpdated       EventType = "competition.updated"
+	EventCompetitionUnlocked      EventType = "competition.unlocked"
+	EventCompetitionResultSet     EventType = "competition.result_set"
+	EventCompetitionResultCleared EventType = "competition.result_cleared"
+	EventCompetitorAdded          EventType = "competitor.added"
+	EventCompetitorRemoved        EventType = "competitor.removed"
+	EventBetterJoined             EventType = "better.joined"
+	EventMemberUpdated            EventType = "member.updated"
+	EventMemberRemoved            EventType = "member.removed"
+	EventPresenceJoined           EventType = "presence.joined"
+	EventPresenceLeft             EventType = "presence.left"
+
+	// EventResync is sent to a reconnecting client when the missed events
+	// can't be replayed. The sequence is set to the latest sequence in the
+	// competition.
+	EventResync EventType = "resync"
+)
+
+// Event is the envelope for all realtime events sent to clients. The sequence
+// number is increased for each event in a competition.
+type Event struct {
+	Version       int         `json:"version"`
+	Type          EventType   `json:"type"`
+	CompetitionID int         `json:"competition_id"`
+	Timestamp     time.Time   `json:"timestamp"`
+	Sequence      int64       `json:"sequence"`
+	Payload       interface{} `json:"payload"`
+}
+
+// ResultSetPayload is the payload for the competition.result_set event.
+type ResultSetPayload struct {
+	Result  []*Result           `json:"result"`
+	Metrics *CompetitionMetrics `json:"metrics"`
+}
+
+// Presence represents a better connected to a competition.
+type Presence struct {
+	Better          *Better `json:"better"`
+	Sessions        int     `json:"sessions"`
+	FinishedBetting bool    `json:"finished_betting"`
+}
+
+// NewEvent creates a new event of the passed type for a competition. The
+// timestamp and sequence number is set when the event is published.
+func NewEvent(eventType EventType, competitionID int, payload interface{}) *Event {
+	return &Event{
+		Version:       EventVersion,
+		Type:          eventType,
+		CompetitionID: competitionID,
+		Payload:       payload,
+	}
+}
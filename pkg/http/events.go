@@ -0,0 +1,305 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/olahol/melody.v1"
+
+	"github.com/bombsimon/team-betting/pkg"
+)
+
+// DefaultEventLogSize is the default number of events kept for each
+// competition to replay for reconnecting clients. It should be kept below the
+// message buffer size for a melody session since all missed events are
+// written to the session at once.
+const DefaultEventLogSize = 200
+
+// subscriberBufferSize is the number of events that may be queued for a
+// subscriber before it's considered too slow and unsubscribed.
+const subscriberBufferSize = 64
+
+// EventHub keeps track of the sequence numbers and a bounded log of the most
+// recent realtime events in each competition.
+type EventHub struct {
+	mu          sync.Mutex
+	logSize     int
+	sequences   map[int]int64
+	logs        map[int][]*pkg.Event
+	subscribers map[int]map[chan *pkg.Event]struct{}
+}
+
+// NewEventHub creates a new event hub keeping at most logSize events for each
+// competition.
+func NewEventHub(logSize int) *EventHub {
+	return &EventHub{
+		logSize:     logSize,
+		sequences:   map[int]int64{},
+		logs:        map[int][]*pkg.Event{},
+		subscribers: map[int]map[chan *pkg.Event]struct{}{},
+	}
+}
+
+// record will set the timestamp and the next sequence number for the
+// competition on the event, add it to the competition log and send it to all
+// subscribers. Subscribers not keeping up will be unsubscribed and must
+// resume from their last seen event.
+func (h *EventHub) record(event *pkg.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.sequences[event.CompetitionID]++
+
+	event.Sequence = h.sequences[event.CompetitionID]
+	event.Timestamp = time.Now()
+
+	log := append(h.logs[event.CompetitionID], event)
+	if len(log) > h.logSize {
+		log = log[len(log)-h.logSize:]
+	}
+
+	h.logs[event.CompetitionID] = log
+
+	for ch := range h.subscribers[event.CompetitionID] {
+		select {
+		case ch <- event:
+		default:
+			h.unsubscribe(event.CompetitionID, ch)
+		}
+	}
+}
+
+// Subscribe returns a channel that will receive all events recorded for the
+// competition, in order, and a function to unsubscribe. The channel is closed
+// when unsubscribed.
+func (h *EventHub) Subscribe(competitionID int) (<-chan *pkg.Event, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan *pkg.Event, subscriberBufferSize)
+
+	if _, ok := h.subscribers[competitionID]; !ok {
+		h.subscribers[competitionID] = map[chan *pkg.Event]struct{}{}
+	}
+
+	h.subscribers[competitionID][ch] = struct{}{}
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		h.unsubscribe(competitionID, ch)
+	}
+}
+
+// unsubscribe must be called with the lock held.
+func (h *EventHub) unsubscribe(competitionID int, ch chan *pkg.Event) {
+	if _, ok := h.subscribers[competitionID][ch]; !ok {
+		return
+	}
+
+	delete(h.subscribers[competitionID], ch)
+	close(ch)
+
+	if len(h.subscribers[competitionID]) == 0 {
+		delete(h.subscribers, competitionID)
+	}
+}
+
+// Since returns all events for the competition with a sequence number higher
+// than the passed sequence. If the events are no longer in the log, or if the
+// sequence is unknown, false is returned together with the latest sequence
+// number and the client must resync.
+func (h *EventHub) Since(competitionID int, sequence int64) ([]*pkg.Event, int64, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var (
+		latest = h.sequences[competitionID]
+		log    = h.logs[competitionID]
+	)
+
+	if sequence > latest {
+		return nil, latest, false
+	}
+
+	if sequence == latest {
+		return []*pkg.Event{}, latest, true
+	}
+
+	if len(log) == 0 || log[0].Sequence > sequence+1 {
+		return nil, latest, false
+	}
+
+	missed := log[len(log)-int(latest-sequence):]
+	events := make([]*pkg.Event, len(missed))
+
+	copy(events, missed)
+
+	return events, latest, true
+}
+
+// Publish will stamp the event, add it to the log and send it to everyone
+// subscribed to the competition. The full event is kept in the log and bets
+// not yet visible to everyone are hidden when sent to other betters than the
+// one who placed the bet.
+func (s *Service) Publish(event *pkg.Event) error {
+	var (
+		bet, isBet    = event.Payload.(*pkg.Bet)
+		owner, others = event, event
+		err           error
+	)
+
+	// All betters but the one who placed the bet see the same event so
+	// visibility is only checked once for the better and once for the others
+	// instead of for each session. It's checked before taking the publish lock
+	// to not hold it while querying the database.
+	if isBet {
+		owner, err = s.visibleEvent(event, &pkg.Better{ID: bet.BetterID})
+		if err != nil {
+			return err
+		}
+
+		others, err = s.visibleEvent(event, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The event is recorded and broadcasted while holding the lock so events
+	// are sent in the same order as their sequence numbers.
+	s.publishMu.Lock()
+	defer s.publishMu.Unlock()
+
+	s.Events.record(event)
+
+	if !isBet {
+		msg, err := json.Marshal(event)
+		if err != nil {
+			return errors.Wrap(err, "could not marshal event")
+		}
+
+		return s.BroadcastToCompetition(event.CompetitionID, msg)
+	}
+
+	for _, e := range []*pkg.Event{owner, others} {
+		e.Sequence = event.Sequence
+		e.Timestamp = event.Timestamp
+	}
+
+	ownerMsg, err := json.Marshal(owner)
+	if err != nil {
+		return errors.Wrap(err, "could not marshal event")
+	}
+
+	othersMsg, err := json.Marshal(others)
+	if err != nil {
+		return errors.Wrap(err, "could not marshal event")
+	}
+
+	if err := s.WS.BroadcastFilter(ownerMsg, func(session *melody.Session) bool {
+		return inCompetition(session, event.CompetitionID) && sessionBetterID(session) == bet.BetterID
+	}); err != nil {
+		return err
+	}
+
+	return s.WS.BroadcastFilter(othersMsg, func(session *melody.Session) bool {
+		return inCompetition(session, event.CompetitionID) && sessionBetterID(session) != bet.BetterID
+	})
+}
+
+// visibleEvent returns the event as seen by the passed better, or by someone
+// not signed in if the better is nil. Bets not yet visible to everyone are
+// hidden in a copy of the event since the event itself is shared with the log.
+func (s *Service) visibleEvent(event *pkg.Event, better *pkg.Better) (*pkg.Event, error) {
+	bet, ok := event.Payload.(*pkg.Bet)
+	if !ok {
+		return event, nil
+	}
+
+	ctx := context.Background()
+	if better != nil {
+		ctx = pkg.ContextWithBetter(ctx, better)
+	}
+
+	visible, err := s.Betting.VisibleBet(ctx, bet)
+	if err != nil {
+		return nil, err
+	}
+
+	copied := *event
+	copied.Payload = visible
+
+	return &copied, nil
+}
+
+// HandleWebsocketConnect will add the session to the competition presence and
+// replay missed events to a reconnecting session. The last seen sequence
+// number is passed as `since` in the query string when connecting. If the
+// missed events can't be replayed a resync event is sent and the client should
+// reload the competition.
+//
+// Events published while replaying may be received both in the replay and as
+// a broadcast so clients should ignore events with an already seen sequence.
+func (s *Service) HandleWebsocketConnect(session *melody.Session) {
+	defer s.joinPresence(session)
+
+	since := session.Request.URL.Query().Get("since")
+	if since == "" {
+		return
+	}
+
+	competitionID, ok := session.Get(wsCompetitionKey)
+	if !ok {
+		return
+	}
+
+	sequence, err := strconv.ParseInt(since, 10, 64)
+	if err != nil {
+		return
+	}
+
+	better := sessionBetter(session)
+
+	for _, event := range s.missedEvents(competitionID.(int), sequence) {
+		visible, err := s.visibleEvent(event, better)
+		if err != nil {
+			s.Logger.Printf("could not get visible event: %s", err.Error())
+			continue
+		}
+
+		msg, err := json.Marshal(visible)
+		if err != nil {
+			s.Logger.Printf("could not marshal event: %s", err.Error())
+			continue
+		}
+
+		if err := session.Write(msg); err != nil {
+			s.Logger.Printf("could not replay event: %s", err.Error())
+			return
+		}
+	}
+}
+
+// missedEvents returns the events published after the passed sequence or a
+// single resync event if they can't be replayed.
+func (s *Service) missedEvents(competitionID int, sequence int64) []*pkg.Event {
+	events, latest, ok := s.Events.Since(competitionID, sequence)
+	if ok {
+		return events
+	}
+
+	return []*pkg.Event{
+		{
+			Version:       pkg.EventVersion,
+			Type:          pkg.EventResync,
+			CompetitionID: competitionID,
+			Timestamp:     time.Now(),
+			Sequence:      latest,
+		},
+	}
+}
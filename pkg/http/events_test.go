@@ -0,0 +1,248 @@
+package http
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bombsimon/team-betting/pkg"
+)
+
+func recordEvents(hub *EventHub, competitionID, n int) {
+	for i := 0; i < n; i++ {
+		hub.record(&pkg.Event{
+			Version:       pkg.EventVersion,
+			Type:          pkg.EventBetUpserted,
+			CompetitionID: competitionID,
+		})
+	}
+}
+
+func sequences(events []*pkg.Event) []int64 {
+	result := []int64{}
+	for _, event := range events {
+		result = append(result, event.Sequence)
+	}
+
+	return result
+}
+
+func TestEventHub_Since(t *testing.T) {
+	hub := NewEventHub(3)
+
+	recordEvents(hub, 1, 5)
+	recordEvents(hub, 2, 1)
+
+	cases := []struct {
+		description   string
+		competitionID int
+		sequence      int64
+		wantSequences []int64
+		wantLatest    int64
+		wantOK        bool
+	}{
+		{
+			description:   "up to date",
+			competitionID: 1,
+			sequence:      5,
+			wantSequences: []int64{},
+			wantLatest:    5,
+			wantOK:        true,
+		},
+		{
+			description:   "missed events in the log",
+			competitionID: 1,
+			sequence:      3,
+			wantSequences: []int64{4, 5},
+			wantLatest:    5,
+			wantOK:        true,
+		},
+		{
+			description:   "exactly at the start of the log",
+			competitionID: 1,
+			sequence:      2,
+			wantSequences: []int64{3, 4, 5},
+			wantLatest:    5,
+			wantOK:        true,
+		},
+		{
+			description:   "missed events trimmed from the log",
+			competitionID: 1,
+			sequence:      1,
+			wantLatest:    5,
+			wantOK:        false,
+		},
+		{
+			description:   "sequence after the latest",
+			competitionID: 1,
+			sequence:      6,
+			wantLatest:    5,
+			wantOK:        false,
+		},
+		{
+			description:   "sequences are per competition",
+			competitionID: 2,
+			sequence:      0,
+			wantSequences: []int64{1},
+			wantLatest:    1,
+			wantOK:        true,
+		},
+		{
+			description:   "no events in competition",
+			competitionID: 3,
+			sequence:      0,
+			wantSequences: []int64{},
+			wantLatest:    0,
+			wantOK:        true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			events, latest, ok := hub.Since(tc.competitionID, tc.sequence)
+
+			assert.Equal(t, tc.wantOK, ok)
+			assert.Equal(t, tc.wantLatest, latest)
+
+			if !tc.wantOK {
+				assert.Nil(t, events)
+				return
+			}
+
+			assert.Equal(t, tc.wantSequences, sequences(events))
+		})
+	}
+}
+
+func TestEventHub_Subscribe(t *testing.T) {
+	hub := NewEventHub(DefaultEventLogSize)
+
+	events, unsubscribe := hub.Subscribe(1)
+	other, unsubscribeOther := hub.Subscribe(2)
+
+	defer unsubscribeOther()
+
+	recordEvents(hub, 1, 2)
+
+	require.Len(t, events, 2)
+	assert.Equal(t, int64(1), (<-events).Sequence)
+	assert.Equal(t, int64(2), (<-events).Sequence)
+	assert.Empty(t, other, "only events for the subscribed competition are received")
+
+	unsubscribe()
+	unsubscribe()
+
+	_, ok := <-events
+	assert.False(t, ok, "channel is closed when unsubscribed")
+}
+
+func TestEventHub_SlowSubscriber(t *testing.T) {
+	hub := NewEventHub(DefaultEventLogSize)
+
+	slow, unsubscribeSlow := hub.Subscribe(1)
+	defer unsubscribeSlow()
+
+	fast, unsubscribeFast := hub.Subscribe(1)
+	defer unsubscribeFast()
+
+	var received []int64
+
+	for i := 0; i < subscriberBufferSize+1; i++ {
+		recordEvents(hub, 1, 1)
+		received = append(received, (<-fast).Sequence)
+	}
+
+	assert.Len(t, received, subscriberBufferSize+1, "subscribers keeping up receive all events")
+
+	for i := 0; i < subscriberBufferSize; i++ {
+		event, ok := <-slow
+		require.True(t, ok)
+		assert.Equal(t, int64(i+1), event.Sequence)
+	}
+
+	_, ok := <-slow
+	assert.False(t, ok, "subscriber with a full buffer is unsubscribed")
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	assert.Len(t, hub.subscribers[1], 1)
+}
+
+func TestEventHub_Record(t *testing.T) {
+	hub := NewEventHub(DefaultEventLogSize)
+
+	first := pkg.NewEvent(pkg.EventCompetitorAdded, 1, &pkg.Competitor{ID: 10})
+
+	assert.Equal(t, pkg.EventVersion, first.Version)
+	assert.Equal(t, pkg.EventCompetitorAdded, first.Type)
+	assert.Equal(t, 1, first.CompetitionID)
+	assert.Zero(t, first.Sequence, "sequence is set when recorded")
+	assert.True(t, first.Timestamp.IsZero(), "timestamp is set when recorded")
+
+	before := time.Now()
+
+	hub.record(first)
+
+	second := pkg.NewEvent(pkg.EventCompetitorAdded, 1, nil)
+	hub.record(second)
+
+	other := pkg.NewEvent(pkg.EventCompetitorAdded, 2, nil)
+	hub.record(other)
+
+	assert.Equal(t, int64(1), first.Sequence)
+	assert.Equal(t, int64(2), second.Sequence)
+	assert.Equal(t, int64(1), other.Sequence, "sequences are per competition")
+	assert.False(t, first.Timestamp.Before(before))
+
+	msg, err := json.Marshal(first)
+	require.NoError(t, err)
+
+	var envelope map[string]interface{}
+
+	require.NoError(t, json.Unmarshal(msg, &envelope))
+
+	assert.Equal(t, float64(pkg.EventVersion), envelope["version"])
+	assert.Equal(t, string(pkg.EventCompetitorAdded), envelope["type"])
+	assert.Equal(t, float64(1), envelope["competition_id"])
+	assert.Equal(t, float64(1), envelope["sequence"])
+	assert.NotEmpty(t, envelope["timestamp"])
+	assert.Equal(t, float64(10), envelope["payload"].(map[string]interface{})["id"])
+}
+
+func TestService_Publish_Order(t *testing.T) {
+	const (
+		publishers = 10
+		perPublish = 20
+	)
+
+	var (
+		s      = newTestService(DefaultEventLogSize)
+		server = websocketServer(t, s)
+		conn   = dialCompetition(t, s, server, 1, "token-1")
+		wg     sync.WaitGroup
+	)
+
+	for i := 0; i < publishers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < perPublish; j++ {
+				assert.NoError(t, s.Publish(pkg.NewEvent(pkg.EventCompetitionLocked, 1, nil)))
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	for i := 1; i <= publishers*perPublish; i++ {
+		event := readWebsocketEvent(t, conn)
+		require.Equal(t, int64(i), event.Sequence, "events are received in sequence order")
+	}
+}
@@ -2,11 +2,11 @@ package http
 
 import (
 	"context"
-	"encoding/base64"
-	"encoding/json"
 	"log"
 	"net/http"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/bombsimon/team-betting/pkg"
 	"github.com/gin-gonic/gin"
@@ -17,12 +17,24 @@ import (
 
 // Service represents the HTTP service serving the team betting.
 type Service struct {
-	Betting pkg.BettingService
-	WS      *melody.Melody
-	Logger  *log.Logger
+	Betting  pkg.BettingService
+	WS       *melody.Melody
+	Events   *EventHub
+	Presence *Presence
+	Logger   *log.Logger
+
+	locks     lockScheduler
+	tickets   streamTickets
+	publishMu sync.Mutex
+
+	// sseKeepAlive overrides the keep-alive interval for event streams when
+	// set.
+	sseKeepAlive time.Duration
 }
 
-// SendSignInEmail will send sign in email.
+// SendSignInEmail will send sign in email. The response is always the same and
+// the email is sent in the background so it's not possible to tell if a better
+// with the email exists.
 func (s *Service) SendSignInEmail(c *gin.Context) {
 	var d struct {
 		Email string `json:"email"`
@@ -33,47 +45,133 @@ func (s *Service) SendSignInEmail(c *gin.Context) {
 		return
 	}
 
-	err := s.Betting.SendSignInEmail(context.Background(), d.Email)
+	go func() {
+		err := s.Betting.SendSignInEmail(context.Background(), d.Email)
+		if err != nil && errors.Cause(err) != pkg.ErrNotFound {
+			s.Logger.Printf("could not send sign in email: %s", err.Error())
+		}
+	}()
 
-	s.HandleResponse(c, nil, nil, err)
+	s.HandleResponse(c, nil, gin.H{
+		"message": "if a better with that email exists a sign in link has been sent",
+	}, nil)
 }
 
 // SignInEmail will sign in from mail.
 func (s *Service) SignInEmail(c *gin.Context) {
-	var (
-		sd   pkg.SignInData
-		data map[string]string
-	)
+	var sd pkg.SignInData
 
 	if err := c.ShouldBindJSON(&sd); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	b64Data, err := base64.StdEncoding.DecodeString(sd.Encoding)
+	data, err := s.Betting.SignInFromEmail(context.Background(), sd.Encoding)
+
+	s.HandleResponse(c, nil, data, err)
+}
+
+// OIDCLogin will redirect to the OpenID Connect provider to sign in.
+func (s *Service) OIDCLogin(c *gin.Context) {
+	authURL, err := s.Betting.OIDCAuthURL(context.Background())
 	if err != nil {
 		s.HandleResponse(c, nil, nil, err)
 		return
 	}
 
-	if err := json.Unmarshal(b64Data, &sd); err != nil {
-		s.HandleResponse(c, nil, nil, err)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback will sign in with the authorization code from the OpenID
+// Connect provider.
+func (s *Service) OIDCCallback(c *gin.Context) {
+	var d struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}
+
+	if err := c.ShouldBindJSON(&d); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	jwtString, err := s.Betting.SignInFromEmail(context.Background(), sd.Email, sd.LinkID)
-	if err == nil {
-		data = map[string]string{
-			"jwt": jwtString,
-		}
+	data, err := s.Betting.SignInFromOIDC(context.Background(), d.Code, d.State)
+
+	s.HandleResponse(c, nil, data, err)
+}
+
+// GetAPITokens returns all API tokens for the current better.
+func (s *Service) GetAPITokens(c *gin.Context) {
+	data, err := s.Betting.GetAPITokens(s.requestContext(c), s.currentUserID(c))
+
+	s.HandleResponse(c, nil, data, err)
+}
+
+// CreateAPIToken creates a new API token for the current better.
+func (s *Service) CreateAPIToken(c *gin.Context) {
+	var token pkg.APIToken
+
+	if err := c.ShouldBindJSON(&token); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
+	data, err := s.Betting.CreateAPIToken(s.requestContext(c), &token)
+
 	s.HandleResponse(c, nil, data, err)
 }
 
+// RevokeAPIToken revokes an API token.
+func (s *Service) RevokeAPIToken(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	err := s.Betting.RevokeAPIToken(s.requestContext(c), id)
+
+	s.HandleResponse(c, nil, nil, err)
+}
+
+// RefreshTokens will exchange a refresh token for a new JWT and refresh token.
+func (s *Service) RefreshTokens(c *gin.Context) {
+	var d struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	if err := c.ShouldBindJSON(&d); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	data, err := s.Betting.RefreshTokens(context.Background(), d.RefreshToken)
+
+	s.HandleResponse(c, nil, data, err)
+}
+
+// Logout will revoke the refresh token so it can't be used again.
+func (s *Service) Logout(c *gin.Context) {
+	var d struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	if err := c.ShouldBindJSON(&d); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := s.Betting.RevokeRefreshToken(context.Background(), d.RefreshToken)
+
+	s.HandleResponse(c, nil, nil, err)
+}
+
+// LogoutEverywhere will invalidate all tokens for the current better, signing
+// them out of every device.
+func (s *Service) LogoutEverywhere(c *gin.Context) {
+	err := s.Betting.SignOutEverywhere(s.requestContext(c), s.currentUserID(c))
+
+	s.HandleResponse(c, nil, nil, err)
+}
+
 // GetCompetitions returns all competitions.
 func (s *Service) GetCompetitions(c *gin.Context) {
-	data, err := s.Betting.GetCompetitions(context.Background(), []int{})
+	data, err := s.Betting.GetCompetitions(s.requestContext(c), []int{})
 
 	s.HandleResponse(c, nil, data, err)
 }
@@ -81,11 +179,147 @@ func (s *Service) GetCompetitions(c *gin.Context) {
 // GetCompetition returns a competition (if it exists).
 func (s *Service) GetCompetition(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
-	data, err := s.Betting.GetCompetition(context.Background(), id)
+	data, err := s.Betting.GetCompetition(s.requestContext(c), id)
 
 	s.HandleResponse(c, nil, data, err)
 }
 
+// GetCompetitionByCode returns a competition based on the competition code.
+func (s *Service) GetCompetitionByCode(c *gin.Context) {
+	data, err := s.Betting.GetCompetitionByCode(s.requestContext(c), c.Param("code"))
+
+	s.HandleResponse(c, nil, data, err)
+}
+
+// JoinCompetition will add the current user as a member of a competition.
+func (s *Service) JoinCompetition(c *gin.Context) {
+	var (
+		event    *pkg.Event
+		betterID = s.currentUserID(c)
+	)
+
+	data, err := s.Betting.JoinCompetition(s.requestContext(c), c.Param("code"), betterID)
+	if data != nil {
+		for _, member := range data.Members {
+			if member.BetterID == betterID {
+				event = pkg.NewEvent(pkg.EventBetterJoined, data.ID, member.Better)
+			}
+		}
+	}
+
+	s.HandleResponse(c, event, data, err)
+}
+
+// JoinCompetitionAsGuest joins a competition by code as a guest with only a
+// name.
+func (s *Service) JoinCompetitionAsGuest(c *gin.Context) {
+	var (
+		in struct {
+			Name string `json:"name"`
+		}
+		event *pkg.Event
+	)
+
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	data, err := s.Betting.JoinCompetitionAsGuest(context.Background(), c.Param("code"), in.Name)
+	if err == nil {
+		if guest, gErr := s.Betting.BetterFromJWT(context.Background(), data.JWT); gErr == nil {
+			event = pkg.NewEvent(pkg.EventBetterJoined, int(guest.GuestCompetitionID.Int64), guest)
+		}
+	}
+
+	s.HandleResponse(c, event, data, err)
+}
+
+// UpgradeGuest upgrades the current guest better to a regular better by adding
+// an email.
+func (s *Service) UpgradeGuest(c *gin.Context) {
+	var in struct {
+		Email string `json:"email"`
+	}
+
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	data, err := s.Betting.UpgradeGuest(s.requestContext(c), s.currentUserID(c), in.Email)
+
+	s.HandleResponse(c, nil, data, err)
+}
+
+// InviteMember adds a better as a member of a competition.
+func (s *Service) InviteMember(c *gin.Context) {
+	var (
+		in struct {
+			BetterID int    `json:"better_id"`
+			Role     string `json:"role"`
+		}
+		event *pkg.Event
+	)
+
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	data, err := s.Betting.InviteMember(s.requestContext(c), id, in.BetterID, in.Role)
+	if data != nil {
+		if better, bErr := s.Betting.GetBetter(s.requestContext(c), data.BetterID); bErr == nil {
+			data.Better = better
+		}
+
+		event = pkg.NewEvent(pkg.EventBetterJoined, id, data.Better)
+	}
+
+	s.HandleResponse(c, event, data, err)
+}
+
+// PromoteMember promotes a member of a competition to co-host.
+func (s *Service) PromoteMember(c *gin.Context) {
+	s.setMemberRole(c, pkg.RoleCoHost)
+}
+
+// DemoteMember demotes a member of a competition to participant.
+func (s *Service) DemoteMember(c *gin.Context) {
+	s.setMemberRole(c, pkg.RoleParticipant)
+}
+
+func (s *Service) setMemberRole(c *gin.Context, role string) {
+	var event *pkg.Event
+
+	id, _ := strconv.Atoi(c.Param("id"))
+	betterID, _ := strconv.Atoi(c.Param("betterID"))
+
+	data, err := s.Betting.SetMemberRole(s.requestContext(c), id, betterID, role)
+	if data != nil {
+		event = pkg.NewEvent(pkg.EventMemberUpdated, id, data)
+	}
+
+	s.HandleResponse(c, event, data, err)
+}
+
+// RemoveMember removes a member from a competition.
+func (s *Service) RemoveMember(c *gin.Context) {
+	var event *pkg.Event
+
+	id, _ := strconv.Atoi(c.Param("id"))
+	betterID, _ := strconv.Atoi(c.Param("betterID"))
+
+	err := s.Betting.RemoveMember(s.requestContext(c), id, betterID)
+	if err == nil {
+		event = pkg.NewEvent(pkg.EventMemberRemoved, id, gin.H{"better_id": betterID})
+	}
+
+	s.HandleResponse(c, event, nil, err)
+}
+
 // AddCompetition adds a competition.
 func (s *Service) AddCompetition(c *gin.Context) {
 	var competition pkg.Competition
@@ -97,16 +331,50 @@ func (s *Service) AddCompetition(c *gin.Context) {
 
 	competition.CreatedByID = s.currentUserID(c)
 
-	data, err := s.Betting.AddCompetition(context.Background(), &competition)
+	data, err := s.Betting.AddCompetition(s.requestContext(c), &competition)
+	if err == nil {
+		s.scheduleLock(data)
+	}
 
 	s.HandleResponse(c, nil, data, err)
 }
 
+// UpdateCompetition updates the fields passed for a competition.
+func (s *Service) UpdateCompetition(c *gin.Context) {
+	var (
+		patch pkg.Patch
+		event *pkg.Event
+	)
+
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	data, err := s.Betting.UpdateCompetition(s.requestContext(c), id, patch)
+	if err == nil {
+		s.scheduleLock(data)
+
+		// The event is sent to every better so the competition is fetched
+		// without a better to only include bets visible to everyone.
+		if competition, cErr := s.Betting.GetCompetition(context.Background(), id); cErr == nil {
+			event = pkg.NewEvent(pkg.EventCompetitionUpdated, id, competition)
+		}
+	}
+
+	s.HandleResponse(c, event, data, err)
+}
+
 // DeleteCompetition returns a competition (if it exists).
 func (s *Service) DeleteCompetition(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
 
-	err := s.Betting.DeleteCompetition(context.Background(), id)
+	err := s.Betting.DeleteCompetition(s.requestContext(c), id)
+	if err == nil {
+		s.locks.cancel(id)
+	}
 
 	s.HandleResponse(c, nil, nil, err)
 }
@@ -115,9 +383,49 @@ func (s *Service) DeleteCompetition(c *gin.Context) {
 func (s *Service) LockCompetition(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
 
-	err := s.Betting.LockCompetition(context.Background(), id)
+	var event *pkg.Event
 
-	s.HandleResponse(c, nil, nil, err)
+	err := s.Betting.LockCompetition(s.requestContext(c), id)
+	if err == nil {
+		s.locks.cancel(id)
+
+		if lEvent, lErr := s.lockedEvent(id); lErr == nil {
+			event = lEvent
+		}
+	}
+
+	s.HandleResponse(c, event, nil, err)
+}
+
+// UnlockCompetition will unlock a competition.
+func (s *Service) UnlockCompetition(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	var event *pkg.Event
+
+	err := s.Betting.UnlockCompetition(s.requestContext(c), id)
+	if err == nil {
+		if competition, cErr := s.Betting.GetCompetition(context.Background(), id); cErr == nil {
+			// A lock scheduled in the future is kept.
+			s.scheduleLock(competition)
+
+			event = pkg.NewEvent(pkg.EventCompetitionUnlocked, id, competition)
+		}
+	}
+
+	s.HandleResponse(c, event, nil, err)
+}
+
+// lockedEvent returns the event published when a competition is locked. The
+// event is sent to every better so the competition is fetched without a
+// better to only include bets visible to everyone.
+func (s *Service) lockedEvent(id int) (*pkg.Event, error) {
+	competition, err := s.Betting.GetCompetition(context.Background(), id)
+	if err != nil {
+		return nil, err
+	}
+
+	return pkg.NewEvent(pkg.EventCompetitionLocked, id, competition), nil
 }
 
 // SetCompetitionResult will set the result for a competition.
@@ -131,14 +439,52 @@ func (s *Service) SetCompetitionResult(c *gin.Context) {
 		return
 	}
 
-	data, err := s.Betting.SetCompetitionResult(context.Background(), id, result)
+	var event *pkg.Event
+
+	data, err := s.Betting.SetCompetitionResult(s.requestContext(c), id, result)
+	if data != nil {
+		event = pkg.NewEvent(pkg.EventCompetitionResultSet, id, &pkg.ResultSetPayload{
+			Result:  result,
+			Metrics: data,
+		})
+	}
+
+	s.HandleResponse(c, event, data, err)
+}
+
+// ClearCompetitionResult will remove the result for a competition.
+func (s *Service) ClearCompetitionResult(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	var event *pkg.Event
+
+	err := s.Betting.ClearCompetitionResult(s.requestContext(c), id)
+	if err == nil {
+		event = pkg.NewEvent(pkg.EventCompetitionResultCleared, id, nil)
+	}
+
+	s.HandleResponse(c, event, nil, err)
+}
+
+// GetCompetitionLeaderboard returns the leaderboard for a competition.
+func (s *Service) GetCompetitionLeaderboard(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	data, err := s.Betting.GetCompetitionLeaderboard(s.requestContext(c), id)
+
+	s.HandleResponse(c, nil, data, err)
+}
+
+// GetCompetitionAudit returns the audit log for a competition.
+func (s *Service) GetCompetitionAudit(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	data, err := s.Betting.GetCompetitionAudit(s.requestContext(c), id)
 
 	s.HandleResponse(c, nil, data, err)
 }
 
 // GetCompetitors returns all competitions.
 func (s *Service) GetCompetitors(c *gin.Context) {
-	data, err := s.Betting.GetCompetitors(context.Background(), []int{})
+	data, err := s.Betting.GetCompetitors(s.requestContext(c), []int{})
 
 	s.HandleResponse(c, nil, data, err)
 }
@@ -146,7 +492,7 @@ func (s *Service) GetCompetitors(c *gin.Context) {
 // GetCompetitor returns a competition (if it exists).
 func (s *Service) GetCompetitor(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
-	data, err := s.Betting.GetCompetitor(context.Background(), id)
+	data, err := s.Betting.GetCompetitor(s.requestContext(c), id)
 
 	s.HandleResponse(c, nil, data, err)
 }
@@ -163,9 +509,82 @@ func (s *Service) AddCompetitor(c *gin.Context) {
 		return
 	}
 
+	var event *pkg.Event
+
 	in.Competitor.CreatedByID = s.currentUserID(c)
 
-	data, err := s.Betting.AddCompetitor(context.Background(), &in.Competitor, in.CompetitionID)
+	data, err := s.Betting.AddCompetitor(s.requestContext(c), &in.Competitor, in.CompetitionID)
+	if data != nil && in.CompetitionID != nil {
+		event = pkg.NewEvent(pkg.EventCompetitorAdded, *in.CompetitionID, data)
+	}
+
+	s.HandleResponse(c, event, data, err)
+}
+
+// LinkCompetitors adds existing competitors to a competition. A single
+// competitor is passed as `competitorID` in the path and multiple competitors
+// as `competitor_ids` in the body. Only the competitors not already competing
+// are returned.
+func (s *Service) LinkCompetitors(c *gin.Context) {
+	var in struct {
+		CompetitorIDs []int `json:"competitor_ids"`
+	}
+
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	if competitorID := c.Param("competitorID"); competitorID != "" {
+		cid, _ := strconv.Atoi(competitorID)
+		in.CompetitorIDs = []int{cid}
+	} else if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	data, err := s.Betting.LinkCompetitors(s.requestContext(c), id, in.CompetitorIDs)
+
+	// Only one event may be passed when responding so the events for all
+	// newly linked competitors are published here.
+	for _, competitor := range data {
+		if pErr := s.Publish(pkg.NewEvent(pkg.EventCompetitorAdded, id, competitor)); pErr != nil {
+			s.Logger.Printf("could not publish %s event to competition %d: %s", pkg.EventCompetitorAdded, id, pErr.Error())
+		}
+	}
+
+	s.HandleResponse(c, nil, data, err)
+}
+
+// UnlinkCompetitor removes a competitor from a competition. Bets placed on the
+// competitor are deleted if `force=true` is passed in the query string,
+// otherwise the competitor isn't removed if it has bets.
+func (s *Service) UnlinkCompetitor(c *gin.Context) {
+	var event *pkg.Event
+
+	id, _ := strconv.Atoi(c.Param("id"))
+	competitorID, _ := strconv.Atoi(c.Param("competitorID"))
+	force, _ := strconv.ParseBool(c.Query("force"))
+
+	err := s.Betting.UnlinkCompetitor(s.requestContext(c), id, competitorID, force)
+	if err == nil {
+		if competitor, cErr := s.Betting.GetCompetitor(s.requestContext(c), competitorID); cErr == nil {
+			event = pkg.NewEvent(pkg.EventCompetitorRemoved, id, competitor)
+		}
+	}
+
+	s.HandleResponse(c, event, nil, err)
+}
+
+// UpdateCompetitor updates the fields passed for a competitor.
+func (s *Service) UpdateCompetitor(c *gin.Context) {
+	var patch pkg.Patch
+
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	data, err := s.Betting.UpdateCompetitor(s.requestContext(c), id, patch)
 
 	s.HandleResponse(c, nil, data, err)
 }
@@ -174,14 +593,14 @@ func (s *Service) AddCompetitor(c *gin.Context) {
 func (s *Service) DeleteCompetitor(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
 
-	err := s.Betting.DeleteCompetitor(context.Background(), id)
+	err := s.Betting.DeleteCompetitor(s.requestContext(c), id)
 
 	s.HandleResponse(c, nil, nil, err)
 }
 
 // GetBetters returns all competitions.
 func (s *Service) GetBetters(c *gin.Context) {
-	data, err := s.Betting.GetBetters(context.Background(), []int{})
+	data, err := s.Betting.GetBetters(s.requestContext(c), []int{})
 
 	s.HandleResponse(c, nil, data, err)
 }
@@ -189,7 +608,7 @@ func (s *Service) GetBetters(c *gin.Context) {
 // GetBetter returns a competition (if it exists).
 func (s *Service) GetBetter(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
-	data, err := s.Betting.GetBetter(context.Background(), id)
+	data, err := s.Betting.GetBetter(s.requestContext(c), id)
 
 	s.HandleResponse(c, nil, data, err)
 }
@@ -204,25 +623,38 @@ func (s *Service) AddBetter(c *gin.Context) {
 	}
 
 	data, err := s.Betting.AddBetter(context.Background(), &better)
-	response := map[string]string{
-		"jwt": data,
+
+	s.HandleResponse(c, nil, data, err)
+}
+
+// UpdateBetter updates the fields passed for a better.
+func (s *Service) UpdateBetter(c *gin.Context) {
+	var patch pkg.Patch
+
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	s.HandleResponse(c, nil, response, err)
+	data, err := s.Betting.UpdateBetter(s.requestContext(c), id, patch)
+
+	s.HandleResponse(c, nil, data, err)
 }
 
 // DeleteBetter returns a better (if it exists).
 func (s *Service) DeleteBetter(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
 
-	err := s.Betting.DeleteBetter(context.Background(), id)
+	err := s.Betting.DeleteBetter(s.requestContext(c), id)
 
 	s.HandleResponse(c, nil, nil, err)
 }
 
 // GetBets returns all competitions.
 func (s *Service) GetBets(c *gin.Context) {
-	data, err := s.Betting.GetBets(context.Background(), []int{})
+	data, err := s.Betting.GetBets(s.requestContext(c), []int{})
 
 	s.HandleResponse(c, nil, data, err)
 }
@@ -230,7 +662,7 @@ func (s *Service) GetBets(c *gin.Context) {
 // GetBet returns a competition (if it exists).
 func (s *Service) GetBet(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
-	data, err := s.Betting.GetBet(context.Background(), id)
+	data, err := s.Betting.GetBet(s.requestContext(c), id)
 
 	s.HandleResponse(c, nil, data, err)
 }
@@ -238,8 +670,8 @@ func (s *Service) GetBet(c *gin.Context) {
 // AddBet adds a bet.
 func (s *Service) AddBet(c *gin.Context) {
 	var (
-		bet pkg.Bet
-		bc  []byte
+		bet   pkg.Bet
+		event *pkg.Event
 	)
 
 	if err := c.ShouldBindJSON(&bet); err != nil {
@@ -247,43 +679,90 @@ func (s *Service) AddBet(c *gin.Context) {
 		return
 	}
 
-	bet.BetterID = s.currentUserID(c)
+	// Bets are placed for the better making the request unless the owner is
+	// overriding the lock to correct a bet for another better.
+	if override, _ := strconv.ParseBool(c.Query("override")); !override || bet.BetterID == 0 {
+		bet.BetterID = s.currentUserID(c)
+	}
 
-	data, err := s.Betting.AddBet(context.Background(), &bet)
+	data, err := s.Betting.AddBet(s.requestContext(c), &bet)
 	if data != nil {
-		bc, _ = json.MarshalIndent(&data, "", "  ")
+		event = pkg.NewEvent(pkg.EventBetUpserted, data.CompetitionID, data)
 	}
 
-	s.HandleResponse(c, bc, data, err)
+	s.HandleResponse(c, event, data, err)
 }
 
 // DeleteBet returns a bet (if it exists).
 func (s *Service) DeleteBet(c *gin.Context) {
+	var event *pkg.Event
+
 	id, _ := strconv.Atoi(c.Param("id"))
 
-	err := s.Betting.DeleteBet(context.Background(), id)
+	// Get the bet before it's deleted to know what competition to notify.
+	bet, err := s.Betting.GetBet(s.requestContext(c), id)
+	if err != nil {
+		s.HandleResponse(c, nil, nil, err)
+		return
+	}
+
+	err = s.Betting.DeleteBet(s.requestContext(c), id)
+	if err == nil {
+		event = pkg.NewEvent(pkg.EventBetDeleted, bet.CompetitionID, bet)
+	}
 
-	s.HandleResponse(c, nil, nil, err)
+	s.HandleResponse(c, event, nil, err)
 }
 
 func (s *Service) currentUserID(c *gin.Context) int {
+	better, ok := s.currentBetter(c)
+	if !ok {
+		return -1
+	}
+
+	return better.ID
+}
+
+func (s *Service) currentBetter(c *gin.Context) (*pkg.Better, bool) {
 	b, ok := c.Get("better")
 	if !ok {
 		s.Logger.Print("no or invalid authorization header")
-		return -1
+		return nil, false
 	}
 
 	better, ok := b.(*pkg.Better)
 	if !ok {
 		s.Logger.Print("authorization isn't a better")
-		return -1
+		return nil, false
 	}
 
-	return better.ID
+	return better, true
+}
+
+// requestContext returns a context carrying the authenticated better which is
+// used by the betting service to authorize the request.
+func (s *Service) requestContext(c *gin.Context) context.Context {
+	ctx := context.Background()
+
+	if better, ok := s.currentBetter(c); ok {
+		ctx = pkg.ContextWithBetter(ctx, better)
+	}
+
+	if scope := c.GetString("scope"); scope != "" {
+		ctx = pkg.ContextWithScope(ctx, scope)
+	}
+
+	// The owner may override the lock to correct bets with `override=true`
+	// in the query string.
+	if override, _ := strconv.ParseBool(c.Query("override")); override {
+		ctx = pkg.ContextWithLockOverride(ctx)
+	}
+
+	return ctx
 }
 
 // HandleResponse will respond according to the object and error passed.
-func (s *Service) HandleResponse(c *gin.Context, broadcast []byte, response interface{}, err error) {
+func (s *Service) HandleResponse(c *gin.Context, event *pkg.Event, response interface{}, err error) {
 	if err != nil {
 		var httpStatus = http.StatusInternalServerError
 
@@ -292,6 +771,10 @@ func (s *Service) HandleResponse(c *gin.Context, broadcast []byte, response inte
 			httpStatus = http.StatusNotFound
 		case pkg.ErrBadRequest:
 			httpStatus = http.StatusBadRequest
+		case pkg.ErrForbidden:
+			httpStatus = http.StatusForbidden
+		case pkg.ErrUnauthorized:
+			httpStatus = http.StatusUnauthorized
 		default:
 			if _, ok := errors.Cause(err).(validation.Errors); ok {
 				httpStatus = http.StatusBadRequest
@@ -303,8 +786,10 @@ func (s *Service) HandleResponse(c *gin.Context, broadcast []byte, response inte
 		return
 	}
 
-	if broadcast != nil {
-		_ = s.WS.Broadcast(broadcast)
+	if event != nil {
+		if pErr := s.Publish(event); pErr != nil {
+			s.Logger.Printf("could not publish %s event to competition %d: %s", event.Type, event.CompetitionID, pErr.Error())
+		}
 	}
 
 	c.JSON(http.StatusOK, response)
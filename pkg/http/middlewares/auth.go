@@ -9,7 +9,9 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// AuthJWT will ensure routes which requires it authenticated for.
+// AuthJWT will ensure routes which requires it authenticated for. The bearer
+// may either be a JWT or a personal API token. The scope of an API token is
+// set as `scope` in the context.
 func AuthJWT(s pkg.BettingService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		auth := c.Request.Header.Get("Authorization")
@@ -24,6 +26,20 @@ func AuthJWT(s pkg.BettingService) gin.HandlerFunc {
 			return
 		}
 
+		if strings.HasPrefix(authorizationParts[1], pkg.APITokenPrefix) {
+			token, err := s.APITokenFromString(context.Background(), authorizationParts[1])
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.Set("better", token.Better)
+			c.Set("scope", token.Scope)
+			c.Next()
+
+			return
+		}
+
 		better, err := s.BetterFromJWT(context.Background(), authorizationParts[1])
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
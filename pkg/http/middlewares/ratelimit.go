@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitStore counts hits for a key in fixed windows. Hit should add a hit
+// for the key and return the number of hits in the current window together
+// with when the window resets.
+type RateLimitStore interface {
+	Hit(key string, window time.Duration) (int, time.Time, error)
+}
+
+// KeyFunc returns the key to rate limit a request on. Requests with an empty
+// key are not rate limited.
+type KeyFunc func(c *gin.Context) string
+
+// RateLimit will allow at most limit requests with the same key within the
+// window and respond with 429 Too Many Requests for the rest. Requests are
+// counted for each route so the same store may be used for all routes.
+func RateLimit(store RateLimitStore, limit int, window time.Duration, keyFunc KeyFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := keyFunc(c)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		hits, resetAt, err := store.Hit(c.FullPath()+":"+key, window)
+		if err != nil {
+			// Don't lock everyone out if the store is unavailable.
+			c.Next()
+			return
+		}
+
+		if hits > limit {
+			retryAfter := math.Ceil(time.Until(resetAt).Seconds())
+
+			c.Header("Retry-After", strconv.Itoa(int(retryAfter)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too many requests"})
+
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// ByIP rate limits on the client IP.
+func ByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// ByEmail rate limits on the email in the JSON body. The body is restored so
+// it can be read again by the handler.
+func ByEmail(c *gin.Context) string {
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		return ""
+	}
+
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	var d struct {
+		Email string `json:"email"`
+	}
+
+	if err := json.Unmarshal(body, &d); err != nil {
+		return ""
+	}
+
+	email := strings.ToLower(strings.TrimSpace(d.Email))
+	if email == "" {
+		return ""
+	}
+
+	return "email:" + email
+}
+
+// MemoryStore is an in memory rate limit store. It's only valid for a single
+// instance of the service.
+type MemoryStore struct {
+	mu        sync.Mutex
+	windows   map[string]*rateWindow
+	lastSweep time.Time
+}
+
+type rateWindow struct {
+	hits    int
+	resetAt time.Time
+}
+
+// NewMemoryStore creates a new in memory rate limit store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		windows:   map[string]*rateWindow{},
+		lastSweep: time.Now(),
+	}
+}
+
+// Hit implements the RateLimitStore interface.
+func (m *MemoryStore) Hit(key string, window time.Duration) (int, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	// Remove expired windows every now and then so the store doesn't grow
+	// with keys that are no longer used.
+	if now.Sub(m.lastSweep) > time.Minute {
+		for k, w := range m.windows {
+			if !w.resetAt.After(now) {
+				delete(m.windows, k)
+			}
+		}
+
+		m.lastSweep = now
+	}
+
+	w, ok := m.windows[key]
+	if !ok || !w.resetAt.After(now) {
+		w = &rateWindow{resetAt: now.Add(window)}
+		m.windows[key] = w
+	}
+
+	w.hits++
+
+	return w.hits, w.resetAt, nil
+}
@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+
+	hits, resetAt, err := store.Hit("key", 50*time.Millisecond)
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, hits)
+
+	hits, secondResetAt, err := store.Hit("key", 50*time.Millisecond)
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, hits)
+	assert.Equal(t, resetAt, secondResetAt, "hits in the same window resets at the same time")
+
+	hits, _, err = store.Hit("other key", 50*time.Millisecond)
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, hits, "keys are counted separately")
+
+	time.Sleep(60 * time.Millisecond)
+
+	hits, rolledResetAt, err := store.Hit("key", 50*time.Millisecond)
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, hits, "a new window is started when the window has passed")
+	assert.True(t, rolledResetAt.After(resetAt))
+}
+
+func TestRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cases := []struct {
+		description string
+		keyFunc     KeyFunc
+		requests    []*http.Request
+		wantStatus  []int
+	}{
+		{
+			description: "limited by IP",
+			keyFunc:     ByIP,
+			requests: []*http.Request{
+				request("10.0.0.1:1234", "", `{}`),
+				request("10.0.0.1:1234", "", `{}`),
+				request("10.0.0.1:1234", "", `{}`),
+				request("10.0.0.2:1234", "", `{}`),
+			},
+			wantStatus: []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests, http.StatusOK},
+		},
+		{
+			description: "forwarded for header from untrusted peer is ignored",
+			keyFunc:     ByIP,
+			requests: []*http.Request{
+				request("10.0.0.1:1234", "1.1.1.1", `{}`),
+				request("10.0.0.1:1234", "2.2.2.2", `{}`),
+				request("10.0.0.1:1234", "3.3.3.3", `{}`),
+			},
+			wantStatus: []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			description: "limited by email regardless of case",
+			keyFunc:     ByEmail,
+			requests: []*http.Request{
+				request("10.0.0.1:1234", "", `{"email": "a@test.se"}`),
+				request("10.0.0.2:1234", "", `{"email": "A@test.se"}`),
+				request("10.0.0.3:1234", "", `{"email": " a@test.se "}`),
+				request("10.0.0.4:1234", "", `{"email": "b@test.se"}`),
+			},
+			wantStatus: []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests, http.StatusOK},
+		},
+		{
+			description: "requests without a key are not limited",
+			keyFunc:     ByEmail,
+			requests: []*http.Request{
+				request("10.0.0.1:1234", "", `{}`),
+				request("10.0.0.1:1234", "", `{}`),
+				request("10.0.0.1:1234", "", `not json`),
+			},
+			wantStatus: []int{http.StatusOK, http.StatusOK, http.StatusOK},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			router := gin.New()
+			require.NoError(t, router.SetTrustedProxies(nil))
+
+			router.POST("/", RateLimit(NewMemoryStore(), 2, time.Minute, tc.keyFunc), func(c *gin.Context) {
+				// The body must still be readable by the handler.
+				var d map[string]interface{}
+				_ = c.ShouldBindJSON(&d)
+
+				c.Status(http.StatusOK)
+			})
+
+			for i, req := range tc.requests {
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, req)
+
+				require.Equal(t, tc.wantStatus[i], rec.Code, "request %d", i)
+
+				if rec.Code == http.StatusTooManyRequests {
+					assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimit_Routes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var (
+		router = gin.New()
+		store  = NewMemoryStore()
+		ok     = func(c *gin.Context) { c.Status(http.StatusOK) }
+	)
+
+	require.NoError(t, router.SetTrustedProxies(nil))
+
+	router.POST("/", RateLimit(store, 1, time.Minute, ByIP), ok)
+	router.POST("/other", RateLimit(store, 1, time.Minute, ByIP), ok)
+
+	for _, target := range []string{"/", "/other"} {
+		req := request("10.0.0.1:1234", "", `{}`)
+		req.URL.Path = target
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code, "requests are counted for each route")
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, request("10.0.0.1:1234", "", `{}`))
+
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+}
+
+func request(remoteAddr, forwardedFor, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.RemoteAddr = remoteAddr
+
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+
+	return req
+}
@@ -0,0 +1,190 @@
+package http
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/olahol/melody.v1"
+
+	"github.com/bombsimon/team-betting/pkg"
+)
+
+// Presence keeps track of the websocket sessions for each better in each
+// competition. A better may be connected with multiple devices and is
+// considered online as long as one session is connected.
+type Presence struct {
+	mu       sync.Mutex
+	sessions map[int]map[int]map[*melody.Session]struct{}
+}
+
+// NewPresence creates a new presence tracker.
+func NewPresence() *Presence {
+	return &Presence{
+		sessions: map[int]map[int]map[*melody.Session]struct{}{},
+	}
+}
+
+// add will add the session and return true if it's the first session for the
+// better in the competition.
+func (p *Presence) add(competitionID int, better *pkg.Better, session *melody.Session) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.sessions[competitionID]; !ok {
+		p.sessions[competitionID] = map[int]map[*melody.Session]struct{}{}
+	}
+
+	if _, ok := p.sessions[competitionID][better.ID]; !ok {
+		p.sessions[competitionID][better.ID] = map[*melody.Session]struct{}{}
+	}
+
+	p.sessions[competitionID][better.ID][session] = struct{}{}
+
+	return len(p.sessions[competitionID][better.ID]) == 1
+}
+
+// remove will remove the session and return true if it was the last session
+// for the better in the competition.
+func (p *Presence) remove(competitionID int, better *pkg.Better, session *melody.Session) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sessions, ok := p.sessions[competitionID][better.ID]
+	if !ok {
+		return false
+	}
+
+	if _, ok := sessions[session]; !ok {
+		return false
+	}
+
+	delete(sessions, session)
+
+	if len(sessions) > 0 {
+		return false
+	}
+
+	delete(p.sessions[competitionID], better.ID)
+
+	if len(p.sessions[competitionID]) == 0 {
+		delete(p.sessions, competitionID)
+	}
+
+	return true
+}
+
+// Online returns the number of connected sessions for each better connected to
+// the competition, keyed on the better ID.
+func (p *Presence) Online(competitionID int) map[int]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	online := map[int]int{}
+
+	for betterID, sessions := range p.sessions[competitionID] {
+		online[betterID] = len(sessions)
+	}
+
+	return online
+}
+
+// GetCompetitionPresence returns all betters currently connected to a
+// competition and if they've placed a bet on every competitor.
+func (s *Service) GetCompetitionPresence(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	competition, err := s.Betting.GetCompetition(context.Background(), id)
+	if err != nil {
+		s.HandleResponse(c, nil, nil, err)
+		return
+	}
+
+	var (
+		online   = s.Presence.Online(competition.ID)
+		betters  = map[int]*pkg.Better{}
+		betCount = map[int]int{}
+		presence = []*pkg.Presence{}
+	)
+
+	for _, member := range competition.Members {
+		betters[member.BetterID] = member.Better
+	}
+
+	for _, bet := range competition.Bets {
+		betters[bet.BetterID] = bet.Better
+		betCount[bet.BetterID]++
+	}
+
+	for betterID, sessions := range online {
+		better, ok := betters[betterID]
+		if !ok {
+			better, err = s.Betting.GetBetter(context.Background(), betterID)
+			if err != nil {
+				continue
+			}
+		}
+
+		presence = append(presence, &pkg.Presence{
+			Better:          better,
+			Sessions:        sessions,
+			FinishedBetting: len(competition.Competitors) > 0 && betCount[betterID] >= len(competition.Competitors),
+		})
+	}
+
+	sort.Slice(presence, func(i, j int) bool {
+		return presence[i].Better.ID < presence[j].Better.ID
+	})
+
+	s.HandleResponse(c, nil, presence, nil)
+}
+
+// HandleWebsocketDisconnect will remove the session from the competition
+// presence and let the competition know if the better left.
+func (s *Service) HandleWebsocketDisconnect(session *melody.Session) {
+	competitionID, better, ok := sessionInfo(session)
+	if !ok {
+		return
+	}
+
+	if !s.Presence.remove(competitionID, better, session) {
+		return
+	}
+
+	if err := s.Publish(pkg.NewEvent(pkg.EventPresenceLeft, competitionID, better)); err != nil {
+		s.Logger.Printf("could not publish presence event: %s", err.Error())
+	}
+}
+
+// joinPresence will add the session to the competition presence and let the
+// competition know if the better joined.
+func (s *Service) joinPresence(session *melody.Session) {
+	competitionID, better, ok := sessionInfo(session)
+	if !ok {
+		return
+	}
+
+	if !s.Presence.add(competitionID, better, session) {
+		return
+	}
+
+	if err := s.Publish(pkg.NewEvent(pkg.EventPresenceJoined, competitionID, better)); err != nil {
+		s.Logger.Printf("could not publish presence event: %s", err.Error())
+	}
+}
+
+func sessionInfo(session *melody.Session) (int, *pkg.Better, bool) {
+	competitionID, ok := session.Get(wsCompetitionKey)
+	if !ok {
+		return 0, nil, false
+	}
+
+	better, ok := session.Get(wsBetterKey)
+	if !ok {
+		return 0, nil, false
+	}
+
+	return competitionID.(int), better.(*pkg.Better), true
+}
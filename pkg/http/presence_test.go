@@ -0,0 +1,76 @@
+package http
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/olahol/melody.v1"
+
+	"github.com/bombsimon/team-betting/pkg"
+)
+
+func testSession(competitionID int, better *pkg.Better) *melody.Session {
+	return &melody.Session{
+		Keys: map[string]interface{}{
+			wsCompetitionKey: competitionID,
+			wsBetterKey:      better,
+		},
+	}
+}
+
+func TestPresence(t *testing.T) {
+	var (
+		presence = NewPresence()
+		first    = &pkg.Better{ID: 1}
+		second   = &pkg.Better{ID: 2}
+		phone    = testSession(1, first)
+		laptop   = testSession(1, first)
+		other    = testSession(1, second)
+	)
+
+	assert.True(t, presence.add(1, first, phone), "first session joins")
+	assert.False(t, presence.add(1, first, laptop), "second session for the same better doesn't join")
+	assert.False(t, presence.add(1, first, laptop), "adding the same session twice doesn't join")
+	assert.True(t, presence.add(1, second, other))
+	assert.True(t, presence.add(2, first, testSession(2, first)), "presence is per competition")
+
+	assert.Equal(t, map[int]int{1: 2, 2: 1}, presence.Online(1))
+
+	assert.False(t, presence.remove(1, first, phone), "better is still online with another session")
+	assert.False(t, presence.remove(1, first, phone), "removing the same session twice doesn't leave")
+	assert.Equal(t, map[int]int{1: 1, 2: 1}, presence.Online(1))
+
+	assert.True(t, presence.remove(1, first, laptop), "last session leaves")
+	assert.Equal(t, map[int]int{2: 1}, presence.Online(1))
+
+	assert.False(t, presence.remove(1, first, laptop), "better not online doesn't leave")
+	assert.Equal(t, map[int]int{1: 1}, presence.Online(2))
+	assert.Equal(t, map[int]int{}, presence.Online(3))
+}
+
+func TestService_Presence(t *testing.T) {
+	var (
+		s      = newTestService(DefaultEventLogSize)
+		better = &pkg.Better{ID: 1}
+		phone  = testSession(1, better)
+		laptop = testSession(1, better)
+	)
+
+	s.joinPresence(phone)
+	s.joinPresence(laptop)
+	s.HandleWebsocketDisconnect(phone)
+	s.HandleWebsocketDisconnect(laptop)
+
+	// Sessions without a better are never added.
+	s.joinPresence(&melody.Session{Keys: map[string]interface{}{wsCompetitionKey: 1}})
+
+	events, _, ok := s.Events.Since(1, 0)
+	require.True(t, ok)
+	require.Len(t, events, 2, "only the first and last session publishes an event")
+
+	assert.Equal(t, pkg.EventPresenceJoined, events[0].Type)
+	assert.Equal(t, better, events[0].Payload)
+	assert.Equal(t, pkg.EventPresenceLeft, events[1].Type)
+	assert.Equal(t, better, events[1].Payload)
+}
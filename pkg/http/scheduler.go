@@ -0,0 +1,117 @@
+package http
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bombsimon/team-betting/pkg"
+)
+
+// lockScheduler keeps a timer for each competition that should be locked at a
+// given time. The zero value is ready to use.
+type lockScheduler struct {
+	mu     sync.Mutex
+	timers map[int]*time.Timer
+}
+
+// schedule will call fn at the passed time for the competition, replacing any
+// previously scheduled call for the same competition.
+func (l *lockScheduler) schedule(competitionID int, at time.Time, fn func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.timers == nil {
+		l.timers = map[int]*time.Timer{}
+	}
+
+	if timer, ok := l.timers[competitionID]; ok {
+		timer.Stop()
+	}
+
+	var timer *time.Timer
+
+	// The timer may fire while it's being replaced or cancelled, before
+	// stopping it, so only the timer still scheduled for the competition
+	// calls fn.
+	timer = time.AfterFunc(time.Until(at), func() {
+		l.mu.Lock()
+
+		if l.timers[competitionID] != timer {
+			l.mu.Unlock()
+			return
+		}
+
+		delete(l.timers, competitionID)
+		l.mu.Unlock()
+
+		fn()
+	})
+
+	l.timers[competitionID] = timer
+}
+
+// cancel will stop the scheduled call for the competition, if any.
+func (l *lockScheduler) cancel(competitionID int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if timer, ok := l.timers[competitionID]; ok {
+		timer.Stop()
+		delete(l.timers, competitionID)
+	}
+}
+
+// LoadScheduledLocks will schedule locking for all competitions with a lock
+// time that isn't locked yet. It should be called when the service starts so
+// scheduled locks survives restarts. Competitions that should already have
+// been locked are locked immediately.
+func (s *Service) LoadScheduledLocks() error {
+	competitions, err := s.Betting.GetScheduledLocks(context.Background())
+	if err != nil {
+		return err
+	}
+
+	for _, competition := range competitions {
+		s.scheduleLock(competition)
+	}
+
+	return nil
+}
+
+// scheduleLock will schedule locking of the competition at its lock time or
+// cancel the scheduled lock if it no longer has one.
+func (s *Service) scheduleLock(competition *pkg.Competition) {
+	if competition.Locked || !competition.LockAt.Valid {
+		s.locks.cancel(competition.ID)
+		return
+	}
+
+	s.locks.schedule(competition.ID, competition.LockAt.Time, func() {
+		s.lockScheduled(competition.ID)
+	})
+}
+
+// lockScheduled will lock the competition and publish the lock event unless
+// it's already locked or rescheduled.
+func (s *Service) lockScheduled(competitionID int) {
+	locked, err := s.Betting.LockScheduledCompetition(context.Background(), competitionID)
+	if err != nil {
+		s.Logger.Printf("could not lock competition %d: %s", competitionID, err.Error())
+		return
+	}
+
+	if !locked {
+		return
+	}
+
+	event, err := s.lockedEvent(competitionID)
+	if err != nil {
+		s.Logger.Printf("could not get locked competition %d: %s", competitionID, err.Error())
+		return
+	}
+
+	if err := s.Publish(event); err != nil {
+		s.Logger.Printf("could not publish %s event to competition %d: %s", event.Type, competitionID, err.Error())
+	}
+}
@@ -0,0 +1,79 @@
+package http
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockScheduler(t *testing.T) {
+	var (
+		locks lockScheduler
+		fired = make(chan string, 10)
+	)
+
+	after := func(d time.Duration) time.Time {
+		return time.Now().Add(d)
+	}
+
+	locks.schedule(1, after(20*time.Millisecond), func() { fired <- "scheduled" })
+	assert.Equal(t, "scheduled", waitFired(t, fired))
+
+	locks.schedule(2, after(20*time.Millisecond), func() { fired <- "replaced" })
+	locks.schedule(2, after(40*time.Millisecond), func() { fired <- "rescheduled" })
+	assert.Equal(t, "rescheduled", waitFired(t, fired), "only the latest schedule is called")
+
+	locks.schedule(3, after(20*time.Millisecond), func() { fired <- "cancelled" })
+	locks.cancel(3)
+
+	time.Sleep(60 * time.Millisecond)
+
+	assert.Empty(t, fired, "replaced and cancelled schedules are never called")
+
+	locks.mu.Lock()
+	defer locks.mu.Unlock()
+
+	assert.Empty(t, locks.timers, "fired timers are removed")
+}
+
+func TestLockScheduler_FiredWhileReplaced(t *testing.T) {
+	var (
+		locks lockScheduler
+		fired = make(chan string, 10)
+	)
+
+	locks.schedule(1, time.Now(), func() { fired <- "old" })
+
+	// Replace the timer the same way as schedule does while the old timer
+	// has fired but waits for the lock.
+	locks.mu.Lock()
+	time.Sleep(20 * time.Millisecond)
+
+	next := time.AfterFunc(time.Hour, func() {})
+	defer next.Stop()
+
+	locks.timers[1] = next
+	locks.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	assert.Empty(t, fired, "the replaced timer is never called")
+
+	locks.mu.Lock()
+	defer locks.mu.Unlock()
+
+	assert.Equal(t, next, locks.timers[1], "the replaced timer doesn't remove the new timer")
+}
+
+func waitFired(t *testing.T, fired <-chan string) string {
+	t.Helper()
+
+	select {
+	case name := <-fired:
+		return name
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for scheduled call")
+		return ""
+	}
+}
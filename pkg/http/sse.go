@@ -0,0 +1,135 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+
+	"github.com/bombsimon/team-betting/pkg"
+)
+
+// sseKeepAliveInterval is how often a comment is sent on idle event streams to
+// prevent proxies from closing the connection.
+const sseKeepAliveInterval = 30 * time.Second
+
+// StreamCompetitionEvents will stream all realtime events for a competition as
+// server-sent events. The event ID is the sequence number so a reconnecting
+// client will resume from the `Last-Event-ID` header. The last seen sequence
+// may also be passed as `since` in the query string.
+//
+// The stream must be authenticated with a JWT or an API token passed as a
+// bearer token in the `Authorization` header. Browsers can't set headers for an
+// EventSource so browser clients first create a stream ticket with
+// `POST /auth/stream-ticket` and pass it as `ticket` in the query string. The
+// token is validated again on each keep-alive and the stream is closed if it
+// has been revoked.
+func (s *Service) StreamCompetitionEvents(c *gin.Context) {
+	token := s.streamCredential(c.Request)
+
+	better, err := s.Betting.BetterFromToken(context.Background(), token)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	competition, err := s.Betting.GetCompetition(context.Background(), id)
+	if err != nil {
+		s.HandleResponse(c, nil, nil, err)
+		return
+	}
+
+	// Subscribe before replaying to not miss any events published in between.
+	events, unsubscribe := s.Events.Subscribe(competition.ID)
+	defer unsubscribe()
+
+	var (
+		lastSent  int64
+		keepAlive = time.NewTicker(s.keepAliveInterval())
+	)
+
+	defer keepAlive.Stop()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Header("Content-Type", "text/event-stream")
+
+	// Send the headers right away so the client knows the stream is open
+	// even if there are no events to replay.
+	c.Writer.WriteHeaderNow()
+	c.Writer.Flush()
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("since")
+	}
+
+	if sequence, err := strconv.ParseInt(lastEventID, 10, 64); err == nil {
+		for _, event := range s.missedEvents(competition.ID, sequence) {
+			s.renderEvent(c, event, better)
+			lastSent = event.Sequence
+		}
+
+		c.Writer.Flush()
+	}
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				// We've been unsubscribed for not keeping up, close the
+				// stream and let the client resume.
+				return false
+			}
+
+			// Skip events already sent in the replay.
+			if event.Sequence <= lastSent {
+				return true
+			}
+
+			s.renderEvent(c, event, better)
+			lastSent = event.Sequence
+		case <-keepAlive.C:
+			if _, err := s.Betting.BetterFromToken(context.Background(), token); err != nil {
+				return false
+			}
+
+			_, _ = io.WriteString(w, ": keep-alive\n\n")
+		case <-c.Request.Context().Done():
+			return false
+		}
+
+		return true
+	})
+}
+
+// keepAliveInterval returns how often a keep-alive is sent on event streams.
+func (s *Service) keepAliveInterval() time.Duration {
+	if s.sseKeepAlive > 0 {
+		return s.sseKeepAlive
+	}
+
+	return sseKeepAliveInterval
+}
+
+// renderEvent will write the event as seen by the better to the stream. An
+// event that can't be rendered is logged and skipped.
+func (s *Service) renderEvent(c *gin.Context, event *pkg.Event, better *pkg.Better) {
+	visible, err := s.visibleEvent(event, better)
+	if err != nil {
+		s.Logger.Printf("could not get visible event: %s", err.Error())
+		return
+	}
+
+	c.Render(-1, sse.Event{
+		Id:    strconv.FormatInt(visible.Sequence, 10),
+		Event: string(visible.Type),
+		Data:  visible,
+	})
+}
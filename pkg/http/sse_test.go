@@ -0,0 +1,354 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guregu/null"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/olahol/melody.v1"
+
+	"github.com/bombsimon/team-betting/pkg"
+)
+
+// fakeBetting is a betting service authenticating betters by token and
+// hiding bets for everyone but the better who placed them. Calling any other
+// method will panic.
+type fakeBetting struct {
+	pkg.BettingService
+
+	mu      sync.Mutex
+	betters map[string]*pkg.Better
+}
+
+func (f *fakeBetting) BetterFromToken(ctx context.Context, tokenString string) (*pkg.Better, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	better, ok := f.betters[tokenString]
+	if !ok {
+		return nil, errors.Wrap(pkg.ErrUnauthorized, "invalid token")
+	}
+
+	return better, nil
+}
+
+// revoke makes the token invalid, like signing out everywhere.
+func (f *fakeBetting) revoke(tokenString string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.betters, tokenString)
+}
+
+func (f *fakeBetting) GetCompetition(ctx context.Context, id int) (*pkg.Competition, error) {
+	return &pkg.Competition{ID: id}, nil
+}
+
+func (f *fakeBetting) VisibleBet(ctx context.Context, bet *pkg.Bet) (*pkg.Bet, error) {
+	visible := *bet
+
+	if better, _ := pkg.BetterFromContext(ctx); better == nil || better.ID != bet.BetterID {
+		visible.Score = null.Int{}
+		visible.Hidden = true
+	}
+
+	return &visible, nil
+}
+
+func newTestService(logSize int) *Service {
+	return &Service{
+		Betting: &fakeBetting{
+			betters: map[string]*pkg.Better{
+				"token-1":                   {ID: 1},
+				"token-2":                   {ID: 2},
+				pkg.APITokenPrefix + "read": {ID: 1},
+			},
+		},
+		WS:       melody.New(),
+		Events:   NewEventHub(logSize),
+		Presence: NewPresence(),
+		Logger:   log.New(ioutil.Discard, "", 0),
+	}
+}
+
+func betEvent(betterID int, score int64) *pkg.Event {
+	return &pkg.Event{
+		Version:       pkg.EventVersion,
+		Type:          pkg.EventBetUpserted,
+		CompetitionID: 1,
+		Payload: &pkg.Bet{
+			BetterID:      betterID,
+			CompetitionID: 1,
+			Score:         null.IntFrom(score),
+		},
+	}
+}
+
+type streamedEvent struct {
+	ID    string
+	Event string
+	Data  struct {
+		Type     pkg.EventType `json:"type"`
+		Sequence int64         `json:"sequence"`
+		Payload  *pkg.Bet      `json:"payload"`
+	}
+}
+
+// readEvents will read n events from the event stream.
+func readEvents(t *testing.T, scanner *bufio.Scanner, n int) []streamedEvent {
+	t.Helper()
+
+	var (
+		events  []streamedEvent
+		current streamedEvent
+	)
+
+	for len(events) < n && scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case strings.HasPrefix(line, "id:"):
+			current.ID = strings.TrimPrefix(line, "id:")
+		case strings.HasPrefix(line, "event:"):
+			current.Event = strings.TrimPrefix(line, "event:")
+		case strings.HasPrefix(line, "data:"):
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data:")), &current.Data))
+		case line == "" && current.ID != "":
+			events = append(events, current)
+			current = streamedEvent{}
+		}
+	}
+
+	require.Len(t, events, n)
+
+	return events
+}
+
+func TestService_StreamCompetitionEvents(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := newTestService(3)
+
+	router := gin.New()
+	router.GET("/competition/:id/events", s.StreamCompetitionEvents)
+	router.POST("/auth/stream-ticket", s.CreateStreamTicket)
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	require.NoError(t, s.Publish(betEvent(1, 10)))
+	require.NoError(t, s.Publish(betEvent(2, 20)))
+	require.NoError(t, s.Publish(betEvent(1, 30)))
+
+	stream := func(t *testing.T, token, lastEventID string) (*bufio.Scanner, func()) {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/competition/1/events", nil)
+		require.NoError(t, err)
+
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Last-Event-ID", lastEventID)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		return bufio.NewScanner(resp.Body), func() {
+			cancel()
+			resp.Body.Close()
+		}
+	}
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/competition/1/events")
+		require.NoError(t, err)
+
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("replays missed events as seen by the better", func(t *testing.T) {
+		scanner, closeStream := stream(t, "token-1", "1")
+		defer closeStream()
+
+		events := readEvents(t, scanner, 2)
+
+		assert.Equal(t, "2", events[0].ID)
+		assert.Equal(t, string(pkg.EventBetUpserted), events[0].Event)
+		assert.True(t, events[0].Data.Payload.Hidden, "other betters' bets are hidden")
+		assert.False(t, events[0].Data.Payload.Score.Valid)
+
+		assert.Equal(t, "3", events[1].ID)
+		assert.False(t, events[1].Data.Payload.Hidden, "own bets are replayed in full")
+		assert.Equal(t, int64(30), events[1].Data.Payload.Score.Int64)
+	})
+
+	t.Run("the same events are replayed in full to the better placing the bet", func(t *testing.T) {
+		scanner, closeStream := stream(t, "token-2", "1")
+		defer closeStream()
+
+		events := readEvents(t, scanner, 2)
+
+		assert.False(t, events[0].Data.Payload.Hidden)
+		assert.Equal(t, int64(20), events[0].Data.Payload.Score.Int64)
+		assert.True(t, events[1].Data.Payload.Hidden)
+	})
+
+	t.Run("resync when events are trimmed from the log", func(t *testing.T) {
+		require.NoError(t, s.Publish(betEvent(2, 40)))
+
+		scanner, closeStream := stream(t, "token-1", "0")
+		defer closeStream()
+
+		events := readEvents(t, scanner, 1)
+
+		assert.Equal(t, "4", events[0].ID)
+		assert.Equal(t, pkg.EventResync, events[0].Data.Type)
+	})
+
+	t.Run("stream ticket", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/auth/stream-ticket", nil)
+		require.NoError(t, err)
+
+		req.Header.Set("Authorization", "Bearer token-1")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		defer resp.Body.Close()
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var ticket struct {
+			Ticket string `json:"ticket"`
+		}
+
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&ticket))
+
+		// Connect without an authorization header like an EventSource.
+		url := server.URL + "/competition/1/events?since=2&ticket=" + ticket.Ticket
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		require.NoError(t, err)
+
+		stream, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		defer stream.Body.Close()
+
+		require.Equal(t, http.StatusOK, stream.StatusCode)
+
+		events := readEvents(t, bufio.NewScanner(stream.Body), 1)
+
+		assert.Equal(t, "3", events[0].ID)
+		assert.False(t, events[0].Data.Payload.Hidden, "the ticket is for the better creating it")
+
+		// Tickets may only be used once.
+		reused, err := http.Get(url)
+		require.NoError(t, err)
+
+		defer reused.Body.Close()
+
+		assert.Equal(t, http.StatusUnauthorized, reused.StatusCode)
+	})
+
+	t.Run("stream ticket requires authentication", func(t *testing.T) {
+		resp, err := http.Post(server.URL+"/auth/stream-ticket", "application/json", nil)
+		require.NoError(t, err)
+
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("API token", func(t *testing.T) {
+		scanner, closeStream := stream(t, pkg.APITokenPrefix+"read", "3")
+		defer closeStream()
+
+		events := readEvents(t, scanner, 1)
+
+		assert.Equal(t, "4", events[0].ID)
+	})
+
+	t.Run("streams published events after the replay", func(t *testing.T) {
+		scanner, closeStream := stream(t, "token-1", "4")
+		defer closeStream()
+
+		// Wait for the stream to subscribe before publishing.
+		require.Eventually(t, func() bool {
+			s.Events.mu.Lock()
+			defer s.Events.mu.Unlock()
+
+			return len(s.Events.subscribers[1]) > 0
+		}, time.Second, 10*time.Millisecond)
+
+		require.NoError(t, s.Publish(betEvent(2, 50)))
+		require.NoError(t, s.Publish(betEvent(1, 60)))
+
+		events := readEvents(t, scanner, 2)
+
+		assert.Equal(t, "5", events[0].ID)
+		assert.True(t, events[0].Data.Payload.Hidden)
+		assert.Equal(t, "6", events[1].ID)
+		assert.Equal(t, int64(60), events[1].Data.Payload.Score.Int64)
+	})
+}
+
+func TestService_StreamCompetitionEvents_Revoked(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := newTestService(DefaultEventLogSize)
+	s.sseKeepAlive = 10 * time.Millisecond
+
+	router := gin.New()
+	router.GET("/competition/:id/events", s.StreamCompetitionEvents)
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/competition/1/events", nil)
+	require.NoError(t, err)
+
+	req.Header.Set("Authorization", "Bearer token-1")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	s.Betting.(*fakeBetting).revoke("token-1")
+
+	// The stream is closed on the next keep-alive.
+	done := make(chan error)
+
+	go func() {
+		_, err := ioutil.ReadAll(resp.Body)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("stream not closed after the token was revoked")
+	}
+}
@@ -0,0 +1,108 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// streamTicketTTL is how long a stream ticket may be used after it's created.
+const streamTicketTTL = 30 * time.Second
+
+// streamTicket is a ticket redeemable for the token it was created with.
+type streamTicket struct {
+	token   string
+	expires time.Time
+}
+
+// streamTickets keeps short lived, single use tickets to authenticate event
+// streams from browsers. An EventSource can't set headers so the ticket is
+// passed in the query string instead of the token itself since it would end
+// up in access logs. The zero value is ready to use.
+type streamTickets struct {
+	mu      sync.Mutex
+	tickets map[string]streamTicket
+}
+
+// create will create a new ticket for the token.
+func (t *streamTickets) create(token string) (string, error) {
+	b := make([]byte, 32)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "could not generate ticket")
+	}
+
+	ticket := base64.RawURLEncoding.EncodeToString(b)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.tickets == nil {
+		t.tickets = map[string]streamTicket{}
+	}
+
+	// Remove tickets never used so they don't pile up.
+	now := time.Now()
+
+	for k, v := range t.tickets {
+		if now.After(v.expires) {
+			delete(t.tickets, k)
+		}
+	}
+
+	t.tickets[ticket] = streamTicket{
+		token:   token,
+		expires: now.Add(streamTicketTTL),
+	}
+
+	return ticket, nil
+}
+
+// redeem will return the token for the ticket and remove it. False is returned
+// if the ticket doesn't exist, has already been used or has expired.
+func (t *streamTickets) redeem(ticket string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	v, ok := t.tickets[ticket]
+	if !ok {
+		return "", false
+	}
+
+	delete(t.tickets, ticket)
+
+	if time.Now().After(v.expires) {
+		return "", false
+	}
+
+	return v.token, true
+}
+
+// CreateStreamTicket will create a single use ticket for the token the request
+// is authenticated with. The ticket is passed as `ticket` in the query string
+// when connecting to an event stream or a websocket and must be used within 30
+// seconds.
+func (s *Service) CreateStreamTicket(c *gin.Context) {
+	token := streamToken(c.Request)
+
+	if _, err := s.Betting.BetterFromToken(s.requestContext(c), token); err != nil {
+		s.HandleResponse(c, nil, nil, err)
+		return
+	}
+
+	ticket, err := s.tickets.create(token)
+	if err != nil {
+		s.HandleResponse(c, nil, nil, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ticket":     ticket,
+		"expires_in": int(streamTicketTTL.Seconds()),
+	})
+}
@@ -0,0 +1,49 @@
+package http
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamTickets(t *testing.T) {
+	var tickets streamTickets
+
+	ticket, err := tickets.create("token-1")
+	require.NoError(t, err)
+
+	other, err := tickets.create("token-1")
+	require.NoError(t, err)
+
+	assert.NotEqual(t, ticket, other)
+
+	token, ok := tickets.redeem(ticket)
+
+	assert.True(t, ok)
+	assert.Equal(t, "token-1", token)
+
+	_, ok = tickets.redeem(ticket)
+	assert.False(t, ok, "tickets may only be used once")
+
+	_, ok = tickets.redeem("unknown")
+	assert.False(t, ok)
+
+	tickets.tickets[other] = streamTicket{
+		token:   "token-1",
+		expires: time.Now().Add(-time.Second),
+	}
+
+	_, ok = tickets.redeem(other)
+	assert.False(t, ok, "expired tickets can't be used")
+
+	// Expired tickets are removed when creating new tickets.
+	tickets.tickets["expired"] = streamTicket{expires: time.Now().Add(-time.Second)}
+
+	_, err = tickets.create("token-2")
+	require.NoError(t, err)
+
+	assert.NotContains(t, tickets.tickets, "expired")
+	assert.Len(t, tickets.tickets, 1)
+}
@@ -0,0 +1,190 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"gopkg.in/olahol/melody.v1"
+
+	"github.com/bombsimon/team-betting/pkg"
+)
+
+// WebsocketProtocol is the websocket subprotocol used by clients passing the
+// JWT in the `Sec-WebSocket-Protocol` header, i.e. `bearer, <token>`.
+const WebsocketProtocol = "bearer"
+
+const (
+	// wsCompetitionKey is the session key holding the competition ID a
+	// websocket session is subscribed to.
+	wsCompetitionKey = "competition_id"
+
+	// wsBetterKey is the session key holding the authenticated better for a
+	// websocket session.
+	wsBetterKey = "better"
+
+	// wsTokenKey is the session key holding the token the session was
+	// authenticated with. It's validated again on each pong.
+	wsTokenKey = "token"
+)
+
+// HandleWebsocket will upgrade the request to a websocket connection. The
+// session will be subscribed to the competition passed as either the
+// competition ID with `competition_id` or the lobby code with `code` in the
+// query string.
+//
+// The connection must be authenticated with a JWT or an API token passed
+// either as a bearer token in the `Authorization` header, with the bearer
+// subprotocol or with a stream ticket passed as `ticket` in the query string.
+func (s *Service) HandleWebsocket(c *gin.Context) {
+	token := s.streamCredential(c.Request)
+
+	better, err := s.Betting.BetterFromToken(context.Background(), token)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	competitionID, err := s.competitionFromQuery(c)
+	if err != nil {
+		s.HandleResponse(c, nil, nil, err)
+		return
+	}
+
+	keys := map[string]interface{}{
+		wsCompetitionKey: competitionID,
+		wsBetterKey:      better,
+		wsTokenKey:       token,
+	}
+
+	if err := s.WS.HandleRequestWithKeys(c.Writer, c.Request, keys); err != nil {
+		s.Logger.Printf("could not handle WS request: %s", err.Error())
+	}
+}
+
+// HandleWebsocketMessage handles messages sent by clients. All events are
+// published by the server so messages from clients are never broadcasted,
+// only logged if they're sent from an unauthenticated session.
+func (s *Service) HandleWebsocketMessage(session *melody.Session, msg []byte) {
+	if _, ok := session.Get(wsBetterKey); !ok {
+		s.Logger.Print("ignoring ws message from unauthenticated session")
+	}
+}
+
+// HandleWebsocketPong will validate the token for the session again on each
+// pong and close the session if the token has been revoked, e.g. if the better
+// signed out everywhere.
+func (s *Service) HandleWebsocketPong(session *melody.Session) {
+	token, ok := session.Get(wsTokenKey)
+	if !ok {
+		return
+	}
+
+	if _, err := s.Betting.BetterFromToken(context.Background(), token.(string)); err == nil {
+		return
+	}
+
+	msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "token has been revoked")
+
+	if err := session.CloseWithMsg(msg); err != nil {
+		s.Logger.Printf("could not close WS session: %s", err.Error())
+	}
+}
+
+// BroadcastToCompetition will send the message to all sessions subscribed to
+// the competition.
+func (s *Service) BroadcastToCompetition(competitionID int, msg []byte) error {
+	return s.WS.BroadcastFilter(msg, func(session *melody.Session) bool {
+		return inCompetition(session, competitionID)
+	})
+}
+
+func (s *Service) competitionFromQuery(c *gin.Context) (int, error) {
+	if code := c.Query("code"); code != "" {
+		competition, err := s.Betting.GetCompetitionByCode(context.Background(), code)
+		if err != nil {
+			return 0, err
+		}
+
+		return competition.ID, nil
+	}
+
+	id, err := strconv.Atoi(c.Query("competition_id"))
+	if err != nil {
+		return 0, errors.Wrap(pkg.ErrBadRequest, "competition_id or code is required")
+	}
+
+	competition, err := s.Betting.GetCompetition(context.Background(), id)
+	if err != nil {
+		return 0, err
+	}
+
+	return competition.ID, nil
+}
+
+// streamToken returns the JWT passed when connecting to a websocket or an
+// event stream. Browsers can't set headers for websockets so the token may
+// also be passed as a subprotocol. The token is never read from the query
+// string since it would end up in access logs.
+func streamToken(r *http.Request) string {
+	if parts := strings.Split(r.Header.Get("Authorization"), " "); len(parts) == 2 {
+		return parts[1]
+	}
+
+	protocols := strings.Split(r.Header.Get("Sec-WebSocket-Protocol"), ",")
+	for i := 0; i < len(protocols)-1; i++ {
+		if strings.TrimSpace(protocols[i]) == WebsocketProtocol {
+			return strings.TrimSpace(protocols[i+1])
+		}
+	}
+
+	return ""
+}
+
+// streamCredential returns the token for a stream ticket passed as `ticket` in
+// the query string, or the token passed in the headers if there's no ticket. A
+// ticket may only be used once.
+func (s *Service) streamCredential(r *http.Request) string {
+	ticket := r.URL.Query().Get("ticket")
+	if ticket == "" {
+		return streamToken(r)
+	}
+
+	token, _ := s.tickets.redeem(ticket)
+
+	return token
+}
+
+func inCompetition(session *melody.Session, competitionID int) bool {
+	id, ok := session.Get(wsCompetitionKey)
+	if !ok {
+		return false
+	}
+
+	return id == competitionID
+}
+
+// sessionBetter returns the better authenticated for the session or nil if
+// unauthenticated.
+func sessionBetter(session *melody.Session) *pkg.Better {
+	better, ok := session.Get(wsBetterKey)
+	if !ok {
+		return nil
+	}
+
+	return better.(*pkg.Better)
+}
+
+// sessionBetterID returns the ID of the better authenticated for the session
+// or 0 if unauthenticated.
+func sessionBetterID(session *melody.Session) int {
+	if better := sessionBetter(session); better != nil {
+		return better.ID
+	}
+
+	return 0
+}
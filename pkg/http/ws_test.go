@@ -0,0 +1,176 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bombsimon/team-betting/pkg"
+)
+
+// websocketServer starts a server handling websocket connections for the
+// service.
+func websocketServer(t *testing.T, s *Service) *httptest.Server {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.GET("/ws", s.HandleWebsocket)
+
+	s.WS.HandlePong(s.HandleWebsocketPong)
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// dialCompetition connects to the competition over a websocket authenticated
+// with the token and waits until the session is registered.
+func dialCompetition(t *testing.T, s *Service, server *httptest.Server, competitionID int, token string) *websocket.Conn {
+	t.Helper()
+
+	var (
+		sessions = s.WS.Len()
+		url      = "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?competition_id=" + strconv.Itoa(competitionID)
+		header   = http.Header{"Authorization": []string{"Bearer " + token}}
+	)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	require.NoError(t, err)
+
+	t.Cleanup(func() { conn.Close() })
+
+	require.Eventually(t, func() bool {
+		return s.WS.Len() > sessions
+	}, time.Second, 5*time.Millisecond)
+
+	return conn
+}
+
+// readWebsocketEvent reads the next event from the connection.
+func readWebsocketEvent(t *testing.T, conn *websocket.Conn) *pkg.Event {
+	t.Helper()
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+
+	var event pkg.Event
+
+	require.NoError(t, conn.ReadJSON(&event))
+
+	return &event
+}
+
+func TestStreamToken(t *testing.T) {
+	cases := []struct {
+		description string
+		target      string
+		headers     map[string]string
+		want        string
+	}{
+		{
+			description: "authorization header",
+			target:      "/ws",
+			headers:     map[string]string{"Authorization": "Bearer the-token"},
+			want:        "the-token",
+		},
+		{
+			description: "bearer subprotocol",
+			target:      "/ws",
+			headers:     map[string]string{"Sec-WebSocket-Protocol": "bearer, the-token"},
+			want:        "the-token",
+		},
+		{
+			description: "other subprotocol",
+			target:      "/ws",
+			headers:     map[string]string{"Sec-WebSocket-Protocol": "chat, the-token"},
+			want:        "",
+		},
+		{
+			description: "bearer subprotocol without token",
+			target:      "/ws",
+			headers:     map[string]string{"Sec-WebSocket-Protocol": "bearer"},
+			want:        "",
+		},
+		{
+			description: "query string is ignored",
+			target:      "/ws?token=the-token",
+			want:        "",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tc.target, nil)
+
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+
+			assert.Equal(t, tc.want, streamToken(r))
+		})
+	}
+}
+
+func TestService_BroadcastToCompetition(t *testing.T) {
+	var (
+		s      = newTestService(DefaultEventLogSize)
+		server = websocketServer(t, s)
+		first  = dialCompetition(t, s, server, 1, "token-1")
+		second = dialCompetition(t, s, server, 2, "token-2")
+	)
+
+	require.NoError(t, s.Publish(pkg.NewEvent(pkg.EventCompetitorAdded, 2, nil)))
+
+	event := readWebsocketEvent(t, second)
+
+	assert.Equal(t, pkg.EventCompetitorAdded, event.Type)
+	assert.Equal(t, 2, event.CompetitionID)
+
+	require.NoError(t, s.Publish(pkg.NewEvent(pkg.EventCompetitionLocked, 1, nil)))
+
+	// The first event received in competition 1 is the one published to it,
+	// nothing published to competition 2 is sent to it.
+	event = readWebsocketEvent(t, first)
+
+	assert.Equal(t, pkg.EventCompetitionLocked, event.Type)
+	assert.Equal(t, 1, event.CompetitionID)
+	assert.Equal(t, int64(1), event.Sequence)
+}
+
+func TestService_HandleWebsocketPong(t *testing.T) {
+	var (
+		s      = newTestService(DefaultEventLogSize)
+		server = websocketServer(t, s)
+	)
+
+	s.WS.Config.PingPeriod = 10 * time.Millisecond
+
+	var (
+		revoked = dialCompetition(t, s, server, 1, "token-1")
+		other   = dialCompetition(t, s, server, 1, "token-2")
+	)
+
+	s.Betting.(*fakeBetting).revoke("token-1")
+
+	// The session is closed on the next pong. Pongs are sent by the client
+	// while reading.
+	require.NoError(t, revoked.SetReadDeadline(time.Now().Add(time.Second)))
+
+	_, _, err := revoked.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation), "unexpected error: %v", err)
+
+	require.NoError(t, s.Publish(pkg.NewEvent(pkg.EventCompetitionLocked, 1, nil)))
+
+	event := readWebsocketEvent(t, other)
+	assert.Equal(t, pkg.EventCompetitionLocked, event.Type)
+}
@@ -1,6 +1,8 @@
 package pkg
 
 import (
+	"errors"
+
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/go-ozzo/ozzo-validation/is"
 )
@@ -9,6 +11,25 @@ import (
 func (c Competition) Validate() error {
 	return validation.ValidateStruct(&c,
 		validation.Field(&c.Name, validation.Required),
+		validation.Field(&c.ScoringRule, validation.In(
+			ScoringExact,
+			ScoringDistance,
+			ScoringEurovision,
+			ScoringSpearman,
+			ScoringKendall,
+		)),
+		validation.Field(&c.BetVisibility, validation.In(
+			BetVisibilityAlways,
+			BetVisibilityAfterLock,
+			BetVisibilityAfterResult,
+		)),
+		validation.Field(&c.LockAt, validation.By(func(interface{}) error {
+			if c.OpensAt.Valid && c.LockAt.Valid && !c.LockAt.Time.After(c.OpensAt.Time) {
+				return errors.New("must be after opens_at")
+			}
+
+			return nil
+		})),
 	)
 }
 
@@ -19,11 +40,17 @@ func (c Competitor) Validate() error {
 	)
 }
 
-// Validate implements validation for a Better.
+// Validate implements validation for a Better. Only guests may be added
+// without an email.
 func (b Better) Validate() error {
+	emailRules := []validation.Rule{is.Email}
+	if !b.GuestCompetitionID.Valid {
+		emailRules = append(emailRules, validation.Required)
+	}
+
 	return validation.ValidateStruct(&b,
 		validation.Field(&b.Name, validation.Required),
-		validation.Field(&b.Email, validation.Required, is.Email),
+		validation.Field(&b.Email, emailRules...),
 	)
 }
 
@@ -44,3 +71,15 @@ func (b Bet) Validate(minScore, maxScore, maxPlacing int) error {
 		validation.Field(&b.Placing, validation.Min(1), validation.Max(maxPlacing)),
 	)
 }
+
+// Validate implements validation for an APIToken.
+func (t APIToken) Validate() error {
+	return validation.ValidateStruct(&t,
+		validation.Field(&t.Name, validation.Required, validation.Length(1, 100)),
+		validation.Field(&t.Scope, validation.Required, validation.In(
+			ScopeRead,
+			ScopeBet,
+			ScopeAdmin,
+		)),
+	)
+}
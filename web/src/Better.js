@@ -38,7 +38,7 @@ export function SaveBetter({ current, flash, onSave }) {
 
         setBetter(initialBetterState);
 
-        localStorage.setItem("authorization", result.jwt);
+        HttpService.StoreTokens(result);
 
         onSave();
       } catch (error) {
@@ -1,10 +1,31 @@
 import axios from "axios";
 
+const BaseURL = "http://localhost:5000";
+
 const client = axios.create({
-  baseURL: "http://localhost:5000"
+  baseURL: BaseURL
 });
 
-function Request(options) {
+function StoreTokens(tokens) {
+  localStorage.setItem("authorization", tokens.jwt);
+  localStorage.setItem("refresh_token", tokens.refresh_token);
+}
+
+// Exchange the refresh token for a new pair of tokens. Refresh tokens may only
+// be used once so the new refresh token must always be stored.
+function Refresh() {
+  return client({
+    method: "post",
+    url: "/auth/refresh",
+    data: { refresh_token: localStorage.getItem("refresh_token") }
+  }).then(response => {
+    StoreTokens(response.data);
+
+    return response.data;
+  });
+}
+
+function Request(options, retried = false) {
   const onSuccess = function(response) {
     console.debug("Request Successful!", response);
 
@@ -21,9 +42,33 @@ function Request(options) {
       console.error("Data:", error.response.data);
       console.error("Headers:", error.response.headers);
 
-      // Clear the JWT if we have an old one and is being rejected.
+      // Try to refresh the JWT once if it's rejected and clear both tokens
+      // if that's not possible.
       if (error.response.status === 401) {
+        if (!retried && localStorage.getItem("refresh_token")) {
+          return Refresh().then(
+            tokens =>
+              Request(
+                {
+                  ...options,
+                  headers: {
+                    ...options.headers,
+                    Authorization: `Bearer ${tokens.jwt}`
+                  }
+                },
+                true
+              ),
+            () => {
+              localStorage.removeItem("authorization");
+              localStorage.removeItem("refresh_token");
+
+              return Promise.reject(error.response);
+            }
+          );
+        }
+
         localStorage.removeItem("authorization");
+        localStorage.removeItem("refresh_token");
       }
     } else {
       // Something else happened while setting up the request
@@ -47,7 +92,9 @@ function GetCompetition(id) {
 }
 
 const HttpService = {
+  BaseURL,
   Request,
+  StoreTokens,
   GetCompetition
 };
 
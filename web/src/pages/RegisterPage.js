@@ -8,25 +8,36 @@ import HttpService from "../HttpClient";
 export default function RegisterPage({ location, flash }) {
   const params = new URLSearchParams(location.search);
   const linkData = params.get("signin");
+  const oidcCode = params.get("code");
+  const oidcState = params.get("state");
 
   const [validLink, setValidLink] = useState(false);
 
   useEffect(() => {
     (async () => {
       try {
-        if (!linkData) {
+        let request;
+
+        if (linkData) {
+          request = {
+            url: "/email/verify",
+            data: { encoding: linkData }
+          };
+        } else if (oidcCode && oidcState) {
+          request = {
+            url: "/auth/oidc/callback",
+            data: { code: oidcCode, state: oidcState }
+          };
+        } else {
           return;
         }
 
         const result = await HttpService.Request({
           method: "post",
-          url: "/email/verify",
-          data: {
-            encoding: linkData
-          }
+          ...request
         });
 
-        localStorage.setItem("authorization", result.jwt);
+        HttpService.StoreTokens(result);
         setValidLink(true);
       } catch (error) {
         // HTTP 400?
@@ -36,7 +47,7 @@ export default function RegisterPage({ location, flash }) {
         });
       }
     })();
-  }, [flash, linkData, setValidLink]);
+  }, [flash, linkData, oidcCode, oidcState, setValidLink]);
 
   const betterSaved = () => {
     setValidLink(true);
@@ -61,6 +72,14 @@ export default function RegisterPage({ location, flash }) {
         Just write your e-mail and we&apos;ll send you a sign in link!
       </p>
       <SendLoginEmail flash={flash} />
+
+      <p className="mt-3">
+        Or{" "}
+        <a href={`${HttpService.BaseURL}/auth/oidc/login`}>
+          sign in with your company account
+        </a>
+        .
+      </p>
     </div>
   );
 }